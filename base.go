@@ -0,0 +1,265 @@
+package dockertestsetup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+	"net"
+	"time"
+)
+
+// ServiceDefaults declares the settings a module uses for everything that
+// was not configured with an option.
+type ServiceDefaults struct {
+	Name            string
+	Repository      string
+	Tag             string
+	Env             []string
+	Cmd             []string
+	Entrypoint      []string
+	ContainerPortId string
+	WaitStrategy    WaitStrategy
+}
+
+// ApplyDefaults fills every setting of c that was not configured with an
+// option from d, falling back to the library wide defaults where d is empty.
+// The container port is bound to HostPort, or to a free port picked by Docker,
+// unless port bindings were configured explicitly.
+func ApplyDefaults(c DockerConfig, d ServiceDefaults) {
+	if len(c.Name()) == 0 {
+		c.SetName(d.Name)
+	}
+
+	if len(c.Repository()) == 0 {
+		c.SetRepository(d.Repository)
+	}
+
+	if len(c.Tag()) == 0 {
+		c.SetTag(d.Tag)
+	}
+	if len(c.Tag()) == 0 {
+		c.SetTag("latest")
+	}
+
+	if len(c.Env()) == 0 {
+		c.SetEnv(d.Env)
+	}
+
+	if len(c.Cmd()) == 0 {
+		c.SetCmd(d.Cmd)
+	}
+
+	if len(c.Entrypoint()) == 0 {
+		c.SetEntrypoint(d.Entrypoint)
+	}
+
+	if len(c.ContainerPortId()) == 0 {
+		c.SetContainerPortId(d.ContainerPortId)
+	}
+
+	if c.ResourceExpire() == 0 {
+		c.SetResourceExpire(60)
+	}
+
+	if c.PoolMaxWait() == 0 {
+		c.SetPoolMaxWait(50 * time.Second)
+	}
+
+	if c.RestartPolicy() == (docker.RestartPolicy{}) {
+		c.SetRestartPolicy(docker.RestartPolicy{
+			Name: "no",
+		})
+	}
+
+	if len(c.PortBindings()) == 0 && len(c.ContainerPortId()) != 0 {
+		c.SetPortBindings(map[docker.Port][]docker.PortBinding{
+			docker.Port(c.ContainerPortId()): {{HostPort: c.HostPort()}},
+		})
+	}
+
+	if c.WaitStrategy() == nil {
+		c.SetWaitStrategy(d.WaitStrategy)
+	}
+}
+
+// BaseResource implements Resource for a started container. Modules embed it
+// and add their clients.
+type BaseResource struct {
+	Name     string
+	resource *dockertest.Resource
+	pool     *dockertest.Pool
+	config   Config
+	connInfo ConnInfo
+	internal ConnInfo
+	err      error
+	cleanups []func(ctx context.Context) error
+	logs     *logCapture
+}
+
+// StartResource connects c, waits for its wait strategy and then calls setup,
+// which is where modules check readiness with their own client. Both share a
+// single PoolMaxWait deadline. Whenever starting fails, the container is
+// released again and the returned resource reports the error.
+func StartResource(ctx context.Context, c Config, setup func(ctx context.Context, r *BaseResource) error) *BaseResource {
+	resource, pool, err := c.ConnectContext(ctx)
+	if err != nil {
+		return NewFailedResource(c.Name(), c, err)
+	}
+
+	r := &BaseResource{
+		Name:     c.Name(),
+		resource: resource,
+		pool:     pool,
+		config:   c,
+		connInfo: hostConnInfo(resource, c.ContainerPortId()),
+		internal: internalConnInfo(c, resource),
+		logs:     captureLogs(pool, resource),
+	}
+
+	// the wait strategy and the readiness checks in setup share PoolMaxWait
+	readyCtx := ctx
+	if c.PoolMaxWait() > 0 {
+		var cancel context.CancelFunc
+		readyCtx, cancel = context.WithTimeout(ctx, c.PoolMaxWait())
+		defer cancel()
+	}
+
+	if err = WaitUntilReady(readyCtx, c, pool, resource); err == nil && setup != nil {
+		err = setup(readyCtx, r)
+	}
+
+	if err != nil {
+		_ = r.CleanupContext(context.Background())
+		failed := NewFailedResource(c.Name(), c, err)
+		failed.logs = r.logs
+		return failed
+	}
+
+	return r
+}
+
+// NewFailedResource returns a resource that reports err and has nothing to
+// clean up but the Cleanup function of config.
+func NewFailedResource(name string, config Config, err error) *BaseResource {
+	return &BaseResource{
+		Name:   name,
+		config: config,
+		err:    err,
+	}
+}
+
+func hostConnInfo(resource *dockertest.Resource, containerPortId string) ConnInfo {
+	if len(containerPortId) == 0 {
+		return ConnInfo{}
+	}
+
+	addr := resource.GetHostPort(containerPortId)
+	host, port, _ := net.SplitHostPort(addr)
+
+	return ConnInfo{
+		Host: host,
+		Port: port,
+		DSN:  addr,
+	}
+}
+
+func (r *BaseResource) GetName() string {
+	return r.Name
+}
+
+func (r *BaseResource) GetError() error {
+	return r.err
+}
+
+func (r *BaseResource) Cleanup() error {
+	return r.CleanupContext(context.Background())
+}
+
+// CleanupContext runs the functions registered with AddCleanup in reverse
+// order, releases the container and finally calls the Cleanup function of
+// the config.
+func (r *BaseResource) CleanupContext(ctx context.Context) error {
+	var errs []error
+
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		if err := r.cleanups[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	r.cleanups = nil
+
+	if r.logs != nil {
+		r.logs.stop()
+	}
+
+	if r.resource != nil {
+		if err := r.config.Release(ctx, r.pool, r.resource); err != nil {
+			errs = append(errs, fmt.Errorf("couldn't purge container: %w", err))
+		} else {
+			r.resource = nil
+		}
+	}
+
+	if r.config != nil {
+		if err := r.config.Cleanup(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// AddCleanup registers f to run before the container is released, e.g. to
+// close a client.
+func (r *BaseResource) AddCleanup(f func(ctx context.Context) error) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *BaseResource) Resource() *dockertest.Resource {
+	return r.resource
+}
+
+func (r *BaseResource) Pool() *dockertest.Pool {
+	return r.pool
+}
+
+func (r *BaseResource) Config() Config {
+	return r.config
+}
+
+func (r *BaseResource) ConnInfo() ConnInfo {
+	return r.connInfo
+}
+
+// Logs returns the stdout and stderr of the container. While the container
+// exists they are read from Docker, afterwards the last LogBufferLines lines
+// captured while it was running are returned.
+func (r *BaseResource) Logs(ctx context.Context) (string, error) {
+	if r.resource != nil {
+		return readLogs(ctx, r.pool, r.resource)
+	}
+	if r.logs != nil {
+		return r.logs.buf.String(), nil
+	}
+	return "", nil
+}
+
+// Exec runs cmd inside the container, e.g. psql or redis-cli, and returns its
+// output and exit code.
+func (r *BaseResource) Exec(ctx context.Context, cmd []string) (stdout, stderr string, exitCode int, err error) {
+	return Exec(ctx, r.pool, r.resource, cmd)
+}
+
+func (r *BaseResource) SetConnInfo(ci ConnInfo) {
+	r.connInfo = ci
+}
+
+func (r *BaseResource) InternalConnInfo() ConnInfo {
+	return r.internal
+}
+
+func (r *BaseResource) SetInternalConnInfo(ci ConnInfo) {
+	r.internal = ci
+}
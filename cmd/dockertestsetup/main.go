@@ -0,0 +1,128 @@
+// Command dockertestsetup lists and removes containers, networks and volumes
+// left behind by test runs that use github.com/kitavrus/dockertestsetup.
+//
+//	dockertestsetup list [-older-than 0s] [-endpoint unix:///var/run/docker.sock]
+//	dockertestsetup prune [-older-than 1h] [-all] [-dry-run] [-endpoint ...]
+//
+// prune leaves reused containers, kept volumes and shared containers that
+// are still in use alone unless -all is given.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	dockertestsetup "github.com/kitavrus/dockertestsetup/v7"
+	dockertest "github.com/ory/dockertest/v3"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = list(ctx, os.Args[2:])
+	case "prune":
+		err = prune(ctx, os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dockertestsetup list|prune [flags]")
+}
+
+func list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "only list resources created at least this long ago")
+	endpoint := fs.String("endpoint", "", "Docker endpoint, DOCKER_HOST by default")
+	_ = fs.Parse(args)
+
+	pool, err := dockertest.NewPool(*endpoint)
+	if err != nil {
+		return fmt.Errorf("could not create docker pool: %w", err)
+	}
+
+	leftovers, err := dockertestsetup.Leftovers(ctx, pool, *olderThan)
+	if err != nil {
+		return err
+	}
+
+	printLeftovers(leftovers)
+	return nil
+}
+
+func prune(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", time.Hour, "only remove resources created at least this long ago")
+	dryRun := fs.Bool("dry-run", false, "list what would be removed without removing it")
+	all := fs.Bool("all", false, "also remove reused containers, kept volumes and shared containers in use")
+	endpoint := fs.String("endpoint", "", "Docker endpoint, DOCKER_HOST by default")
+	_ = fs.Parse(args)
+
+	pool, err := dockertest.NewPool(*endpoint)
+	if err != nil {
+		return fmt.Errorf("could not create docker pool: %w", err)
+	}
+
+	if *dryRun {
+		leftovers, err := dockertestsetup.Leftovers(ctx, pool, *olderThan)
+		if err != nil {
+			return err
+		}
+		removable := leftovers[:0]
+		for _, l := range leftovers {
+			if *all || (!l.Kept && !l.InUse) {
+				removable = append(removable, l)
+			}
+		}
+		printLeftovers(removable)
+		return nil
+	}
+
+	removed, err := dockertestsetup.Prune(ctx, pool, *olderThan, *all)
+	printLeftovers(removed)
+	return err
+}
+
+func printLeftovers(leftovers []dockertestsetup.Leftover) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tSESSION\tMODULE\tAGE\tSTATUS")
+	for _, l := range leftovers {
+		age := "unknown"
+		if !l.Created.IsZero() {
+			age = time.Since(l.Created).Round(time.Second).String()
+		}
+		status := "-"
+		switch {
+		case l.InUse:
+			status = "in use"
+		case l.Kept:
+			status = "kept"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", l.Kind, l.Name, l.Session, l.Module, age, status)
+	}
+	w.Flush()
+}
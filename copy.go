@@ -0,0 +1,171 @@
+package dockertestsetup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Copy is content uploaded into a container after it is created and before
+// it is started.
+type Copy struct {
+	// Dir is the directory in the container the archive is extracted to. It
+	// has to exist in the image.
+	Dir string
+	// Tar returns the tar archive to extract. It's called once per created
+	// container and the archive is closed after the upload.
+	Tar func() (io.ReadCloser, error)
+	// Hash writes what identifies the content to w, for ConfigHash. Without
+	// it only Dir is part of the hash.
+	Hash func(w io.Writer) error
+}
+
+// CfgCopyFile copies the file or directory hostPath to containerPath, e.g. a
+// custom postgresql.conf, TLS certificates or seed data. Missing parent
+// directories are created in the container.
+func CfgCopyFile(hostPath, containerPath string) Options {
+	return func(c Config) {
+		c.SetCopies(append(c.Copies(), Copy{
+			Dir: "/",
+			Tar: func() (io.ReadCloser, error) {
+				return tarPath(hostPath, containerPath)
+			},
+			Hash: func(w io.Writer) error {
+				fmt.Fprintf(w, "%s\x00%s\x00", hostPath, containerPath)
+				return hashPath(w, hostPath)
+			},
+		}))
+	}
+}
+
+// CfgCopyTar extracts the tar archive read from r into containerDir, which has
+// to exist in the image. r is read completely when the option is applied.
+func CfgCopyTar(r io.Reader, containerDir string) Options {
+	return func(c Config) {
+		b, err := io.ReadAll(r)
+		c.SetCopies(append(c.Copies(), Copy{
+			Dir: containerDir,
+			Tar: func() (io.ReadCloser, error) {
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(bytes.NewReader(b)), nil
+			},
+			Hash: func(w io.Writer) error {
+				_, err := w.Write(b)
+				return err
+			},
+		}))
+	}
+}
+
+// hashPath writes the names, modes and contents of hostPath and everything
+// below it to w.
+func hashPath(w io.Writer, hostPath string) error {
+	return filepath.WalkDir(hostPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(hostPath, p)
+		fmt.Fprintf(w, "%s\x00%v\x00", filepath.ToSlash(rel), info.Mode())
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// tarPath streams an archive holding hostPath under the name containerPath.
+// Closing the returned reader stops the goroutine writing the archive.
+func tarPath(hostPath, containerPath string) (io.ReadCloser, error) {
+	if _, err := os.Stat(hostPath); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := filepath.WalkDir(hostPath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(hostPath, p)
+			if err != nil {
+				return err
+			}
+			name := strings.TrimPrefix(path.Join(containerPath, filepath.ToSlash(rel)), "/")
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = name
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+func (c *DockerConfigImpl) upload(ctx context.Context, pool *dockertest.Pool, id string) error {
+	for _, cp := range c.Copies() {
+		r, err := cp.Tar()
+		if err != nil {
+			return fmt.Errorf("couldn't read files to copy to %s: %w", cp.Dir, err)
+		}
+
+		err = pool.Client.UploadToContainer(id, docker.UploadToContainerOptions{
+			Context:     ctx,
+			InputStream: r,
+			Path:        cp.Dir,
+		})
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't copy files to %s: %w", cp.Dir, err)
+		}
+	}
+	return nil
+}
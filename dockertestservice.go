@@ -1,9 +1,12 @@
 package dockertestsetup
 
 import (
+	"context"
 	"fmt"
+	backoff "github.com/cenkalti/backoff/v4"
 	dockertest "github.com/ory/dockertest/v3"
 	docker "github.com/ory/dockertest/v3/docker"
+	"regexp"
 	"time"
 )
 
@@ -60,6 +63,20 @@ type DockerConfigImpl struct {
 	cleanup         func() error
 	hostPort        string
 	containerPortId string
+	dependsOn       []string
+	links           []LinkFunc
+	pool            *dockertest.Pool
+	reuse           bool
+	shared          bool
+	waitStrategy    WaitStrategy
+	copies          []Copy
+	mounts          []Mount
+	network         string
+	networkAliases  []string
+	limits          Limits
+	pullPolicy      PullPolicy
+	registryAuth    *docker.AuthConfiguration
+	registryMirror  string
 }
 
 func (c *DockerConfigImpl) Name() string {
@@ -109,6 +126,9 @@ func (c *DockerConfigImpl) PortBindings() map[docker.Port][]docker.PortBinding {
 	return c.portBindings
 }
 func (c *DockerConfigImpl) Cleanup() error {
+	if c.cleanup == nil {
+		return nil
+	}
 	return c.cleanup()
 }
 
@@ -120,6 +140,62 @@ func (c *DockerConfigImpl) ContainerPortId() string {
 	return c.containerPortId
 }
 
+func (c *DockerConfigImpl) DependsOn() []string {
+	return c.dependsOn
+}
+
+func (c *DockerConfigImpl) Links() []LinkFunc {
+	return c.links
+}
+
+func (c *DockerConfigImpl) Pool() *dockertest.Pool {
+	return c.pool
+}
+
+func (c *DockerConfigImpl) Reuse() bool {
+	return c.reuse
+}
+
+func (c *DockerConfigImpl) Shared() bool {
+	return c.shared
+}
+
+func (c *DockerConfigImpl) WaitStrategy() WaitStrategy {
+	return c.waitStrategy
+}
+
+func (c *DockerConfigImpl) Copies() []Copy {
+	return c.copies
+}
+
+func (c *DockerConfigImpl) Mounts() []Mount {
+	return c.mounts
+}
+
+func (c *DockerConfigImpl) Network() string {
+	return c.network
+}
+
+func (c *DockerConfigImpl) NetworkAliases() []string {
+	return c.networkAliases
+}
+
+func (c *DockerConfigImpl) Limits() Limits {
+	return c.limits
+}
+
+func (c *DockerConfigImpl) PullPolicy() PullPolicy {
+	return c.pullPolicy
+}
+
+func (c *DockerConfigImpl) RegistryAuth() *docker.AuthConfiguration {
+	return c.registryAuth
+}
+
+func (c *DockerConfigImpl) RegistryMirror() string {
+	return c.registryMirror
+}
+
 func (c *DockerConfigImpl) SetName(n string) {
 	c.name = n
 }
@@ -171,6 +247,62 @@ func (c *DockerConfigImpl) SetWorkingDir(w []string) {
 	c.workingDir = w
 }
 
+func (c *DockerConfigImpl) SetDependsOn(d []string) {
+	c.dependsOn = d
+}
+
+func (c *DockerConfigImpl) SetLinks(l []LinkFunc) {
+	c.links = l
+}
+
+func (c *DockerConfigImpl) SetPool(p *dockertest.Pool) {
+	c.pool = p
+}
+
+func (c *DockerConfigImpl) SetReuse(r bool) {
+	c.reuse = r
+}
+
+func (c *DockerConfigImpl) SetShared(s bool) {
+	c.shared = s
+}
+
+func (c *DockerConfigImpl) SetWaitStrategy(w WaitStrategy) {
+	c.waitStrategy = w
+}
+
+func (c *DockerConfigImpl) SetCopies(cp []Copy) {
+	c.copies = cp
+}
+
+func (c *DockerConfigImpl) SetMounts(m []Mount) {
+	c.mounts = m
+}
+
+func (c *DockerConfigImpl) SetNetwork(n string) {
+	c.network = n
+}
+
+func (c *DockerConfigImpl) SetNetworkAliases(a []string) {
+	c.networkAliases = a
+}
+
+func (c *DockerConfigImpl) SetLimits(l Limits) {
+	c.limits = l
+}
+
+func (c *DockerConfigImpl) SetPullPolicy(p PullPolicy) {
+	c.pullPolicy = p
+}
+
+func (c *DockerConfigImpl) SetRegistryAuth(a *docker.AuthConfiguration) {
+	c.registryAuth = a
+}
+
+func (c *DockerConfigImpl) SetRegistryMirror(m string) {
+	c.registryMirror = m
+}
+
 func CfgRepository(repo string, tag string) Options {
 	return func(c Config) {
 		c.SetRepository(repo)
@@ -190,6 +322,18 @@ func CfgEnv(env []string) Options {
 	}
 }
 
+func CfgCmd(cmd ...string) Options {
+	return func(c Config) {
+		c.SetCmd(cmd)
+	}
+}
+
+func CfgEntrypoint(entrypoint ...string) Options {
+	return func(c Config) {
+		c.SetEntrypoint(entrypoint)
+	}
+}
+
 func CfgResourceExpire(re uint) Options {
 	return func(c Config) {
 		c.SetResourceExpire(re)
@@ -208,45 +352,363 @@ func CfgCleanup(f func() error) Options {
 	}
 }
 
+// CfgHostPort binds the container port to a fixed host port. By default
+// Docker assigns a free host port, which Resource.ConnInfo reports.
+func CfgHostPort(port string) Options {
+	return func(c Config) {
+		c.SetHostPort(port)
+	}
+}
+
 func CfgPortBindings(pb map[docker.Port][]docker.PortBinding) Options {
 	return func(c Config) {
 		c.SetPortBindings(pb)
 	}
 }
 
+// CfgPool makes the container use pool instead of connecting to Docker on its
+// own. Containers started by a DockerTestUpper get its shared pool otherwise.
+func CfgPool(pool *dockertest.Pool) Options {
+	return func(c Config) {
+		c.SetPool(pool)
+	}
+}
+
+// CfgReuse turns reuse of an existing container on or off. With reuse on, a
+// running and healthy container with the same name and ConfigHash is reused
+// and kept running after cleanup, an unhealthy one is created anew. A
+// container with the same name but another configuration, one that wasn't
+// created by dockertestsetup or one of another test process that is still
+// running is left alone and reported as ErrNameInUse.
+func CfgReuse(reuse bool) Options {
+	return func(c Config) {
+		c.SetReuse(reuse)
+	}
+}
+
+// CfgShared shares the container between test processes, e.g. the packages
+// of a single go test ./... run. The first process creates the container,
+// later ones attach to it and the last one to release it purges it.
+func CfgShared(shared bool) Options {
+	return func(c Config) {
+		c.SetShared(shared)
+	}
+}
+
+// CfgWaitStrategy sets a wait strategy that has to succeed before the module
+// checks readiness with its own client.
+func CfgWaitStrategy(w WaitStrategy) Options {
+	return func(c Config) {
+		c.SetWaitStrategy(w)
+	}
+}
+
+func CfgDependsOn(names ...string) Options {
+	return func(c Config) {
+		c.SetDependsOn(append(c.DependsOn(), names...))
+	}
+}
+
+func CfgLink(f LinkFunc) Options {
+	return func(c Config) {
+		c.SetLinks(append(c.Links(), f))
+	}
+}
+
+// CfgLinkEnv makes the container depend on dep and adds the environment
+// variable key with the value built from the connection info of dep. It's
+// given Resource.InternalConnInfo, the address dep is reachable at from
+// other containers: its name when both are on a network created with
+// WithNetwork, its bridge IP otherwise.
+func CfgLinkEnv(dep, key string, value func(ConnInfo) string) Options {
+	return func(c Config) {
+		CfgDependsOn(dep)(c)
+		CfgLink(func(c Config, deps map[string]Resource) error {
+			env := append([]string(nil), c.Env()...)
+			c.SetEnv(append(env, key+"="+value(deps[dep].InternalConnInfo())))
+			return nil
+		})(c)
+	}
+}
+
 func (c *DockerConfigImpl) Connect() (*dockertest.Resource, *dockertest.Pool, error) {
+	return c.ConnectContext(context.Background())
+}
+
+func (c *DockerConfigImpl) ConnectContext(ctx context.Context) (*dockertest.Resource, *dockertest.Pool, error) {
+
+	if err := strategyErr(c.WaitStrategy()); err != nil {
+		return nil, nil, NewStartupError(c.Name(), PhaseConnect, ErrInvalidConfig, fmt.Errorf("invalid wait strategy: %w", err))
+	}
+
+	pool, err := c.connectPool(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resource *dockertest.Resource
+	if c.Shared() {
+		resource, err = c.connectShared(ctx, pool)
+	} else {
+		resource, err = c.connect(ctx, pool)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = c.joinNetwork(ctx, pool, resource); err != nil {
+		_ = c.Release(context.Background(), pool, resource)
+		return nil, nil, err
+	}
+
+	return resource, pool, nil
+}
+
+// Release undoes ConnectContext: it purges the container, keeps it running
+// when it's reused, or drops this process' reference when it's shared.
+func (c *DockerConfigImpl) Release(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	switch {
+	case c.Shared():
+		if err := c.leaveNetwork(ctx, pool, resource); err != nil {
+			return err
+		}
+		return c.releaseShared(ctx, pool, resource)
+	case c.Reuse():
+		return c.leaveNetwork(ctx, pool, resource)
+	default:
+		return c.purge(ctx, pool, resource)
+	}
+}
+
+func (c *DockerConfigImpl) connectPool(ctx context.Context) (*dockertest.Pool, error) {
+	if c.Pool() != nil {
+		return c.Pool(), nil
+	}
 
 	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return nil, NewStartupError(c.Name(), PhaseConnect, ErrDockerUnavailable, fmt.Errorf("could not create docker pool: %w", err))
+	}
 
+	err = pool.Client.PingWithContext(ctx)
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not create docker pool: %w", err)
+		return nil, NewStartupError(c.Name(), PhaseConnect, ErrDockerUnavailable, fmt.Errorf("could not connect to Docker: %w", err))
 	}
 
-	err = pool.Client.Ping()
+	return pool, nil
+}
+
+func (c *DockerConfigImpl) connect(ctx context.Context, pool *dockertest.Pool) (*dockertest.Resource, error) {
+	hash := ConfigHash(c)
+
+	if existing, ok := c.containerByName(pool); ok {
+		if c.Reuse() && existing.Container.Config.Labels[LabelConfigHash] == hash && isHealthy(existing.Container) {
+			return existing, nil
+		}
+
+		if err := checkRemovable(c, existing.Container, hash); err != nil {
+			return nil, NewStartupError(c.Name(), PhaseRun, ErrNameInUse, err)
+		}
+
+		if err := Purge(ctx, pool, existing); err != nil {
+			return nil, NewStartupError(c.Name(), PhaseRun, ErrStartFailed, fmt.Errorf("couldn't remove stale container: %w", err))
+		}
+	}
+
+	resource, err := c.create(ctx, pool, map[string]string{LabelConfigHash: hash})
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not connect to Docker: %w", err)
+		return nil, err
+	}
+
+	if !c.Reuse() {
+		if err := resource.Expire(c.ResourceExpire()); err != nil {
+			_ = Purge(context.Background(), pool, resource)
+			return nil, NewStartupError(c.Name(), PhaseRun, ErrStartFailed, err)
+		}
 	}
 
-	resource, isRunning := pool.ContainerByName(c.Name())
+	return resource, nil
+}
+
+func (c *DockerConfigImpl) containerByName(pool *dockertest.Pool) (*dockertest.Resource, bool) {
+	return pool.ContainerByName("^/" + regexp.QuoteMeta(c.Name()) + "$")
+}
+
+func (c *DockerConfigImpl) create(ctx context.Context, pool *dockertest.Pool, labels map[string]string) (*dockertest.Resource, error) {
+	for k, v := range sessionLabels(c) {
+		labels[k] = v
+	}
+
+	if err := c.pullImage(ctx, pool); err != nil {
+		return nil, NewStartupError(c.Name(), PhasePull, ErrPullFailed, err)
+	}
 
-	if !isRunning {
-		resource, err = pool.RunWithOptions(&dockertest.RunOptions{
-			Name:         c.Name(),
-			Repository:   c.Repository(),
-			Tag:          c.Tag(),
+	resource, err := c.run(ctx, pool, labels)
+	if err != nil {
+		return nil, NewStartupError(c.Name(), PhaseRun, runErrorKind(err), err)
+	}
+
+	return resource, nil
+}
+
+func (c *DockerConfigImpl) imageTag() string {
+	if len(c.Tag()) == 0 {
+		return "latest"
+	}
+	return c.Tag()
+}
+
+func (c *DockerConfigImpl) image() string {
+	return c.pullRepository() + ":" + c.imageTag()
+}
+
+// run starts the container in the background so that a hung Docker daemon
+// can't block the caller past ctx. A container that is created after ctx is
+// done gets purged as soon as the daemon reports it.
+func (c *DockerConfigImpl) run(ctx context.Context, pool *dockertest.Pool, labels map[string]string) (*dockertest.Resource, error) {
+	type result struct {
+		resource *dockertest.Resource
+		err      error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		resource, err := c.runContainer(pool, labels)
+		done <- result{resource: resource, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.resource, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				_ = Purge(context.Background(), pool, r.resource)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// runContainer does what pool.RunWithOptions does, but uploads the copies
+// between creating and starting the container.
+func (c *DockerConfigImpl) runContainer(pool *dockertest.Pool, labels map[string]string) (*dockertest.Resource, error) {
+	ctx := context.Background()
+
+	exposedPorts := make(map[docker.Port]struct{})
+	for _, p := range c.exposedPorts() {
+		exposedPorts[docker.Port(p)] = struct{}{}
+	}
+
+	volumes, err := c.createVolumes(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	container, err := pool.Client.CreateContainer(docker.CreateContainerOptions{
+		Context: ctx,
+		Name:    c.Name(),
+		Config: &docker.Config{
+			Image:        c.image(),
 			Env:          c.Env(),
 			Cmd:          c.Cmd(),
 			Entrypoint:   c.Entrypoint(),
-			PortBindings: c.PortBindings(),
-		}, func(config *docker.HostConfig) {
-			config.AutoRemove = c.AutoRemove()
-			config.RestartPolicy = c.RestartPolicy()
+			ExposedPorts: exposedPorts,
+			Labels:       labels,
+			StopSignal:   "SIGWINCH", // same as dockertest, to support timeouts
+		},
+		HostConfig: c.hostConfig(),
+		NetworkingConfig: &docker.NetworkingConfig{
+			EndpointsConfig: c.endpointsConfig(),
+		},
+	})
+	if err != nil {
+		_ = removeNamedVolumes(ctx, pool, volumes)
+		return nil, err
+	}
+
+	remove := func(err error) (*dockertest.Resource, error) {
+		_ = pool.Client.RemoveContainer(docker.RemoveContainerOptions{
+			ID:            container.ID,
+			Force:         true,
+			RemoveVolumes: true,
 		})
+		_ = removeNamedVolumes(ctx, pool, volumes)
+		return nil, err
+	}
+
+	if err = c.upload(ctx, pool, container.ID); err != nil {
+		return remove(err)
+	}
+
+	if err = pool.Client.StartContainerWithContext(container.ID, nil, ctx); err != nil {
+		return remove(err)
+	}
 
-		if err != nil {
-			return nil, nil, fmt.Errorf("couldn't start resource: %w", err)
+	resource, ok := c.containerByName(pool)
+	if !ok {
+		return remove(fmt.Errorf("container %s disappeared after start", c.Name()))
+	}
+
+	return resource, nil
+}
+
+// hostConfig returns the host config the container is created with, starting
+// from the defaults of dockertest.
+func (c *DockerConfigImpl) hostConfig() *docker.HostConfig {
+	hc := &docker.HostConfig{
+		PublishAllPorts: true,
+		PortBindings:    c.PortBindings(),
+		AutoRemove:      c.AutoRemove(),
+		RestartPolicy:   c.RestartPolicy(),
+		Mounts:          c.hostMounts(),
+	}
+	c.Limits().apply(hc)
+	return hc
+}
+
+func (c *DockerConfigImpl) exposedPorts() []string {
+	ports := make([]string, 0, len(c.PortBindings())+1)
+	if len(c.ContainerPortId()) != 0 {
+		ports = append(ports, c.ContainerPortId())
+	}
+	for p := range c.PortBindings() {
+		if string(p) != c.ContainerPortId() {
+			ports = append(ports, string(p))
 		}
 	}
+	return ports
+}
+
+// Retry calls op with an exponential backoff until it succeeds, maxWait
+// elapses or ctx is done.
+func Retry(ctx context.Context, maxWait time.Duration, op func() error) error {
+	if maxWait == 0 {
+		maxWait = time.Minute
+	}
 
-	return resource, pool, nil
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = time.Second * 5
+	bo.MaxElapsedTime = maxWait
+
+	var lastErr error
+	err := backoff.Retry(func() error {
+		lastErr = op()
+		return lastErr
+	}, backoff.WithContext(bo, ctx))
+
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ctx.Err(), lastErr)
+	}
+	return err
+}
+
+// Purge removes the container of resource together with its volumes.
+func Purge(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	return pool.Client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            resource.Container.ID,
+		Force:         true,
+		RemoveVolumes: true,
+		Context:       ctx,
+	})
 }
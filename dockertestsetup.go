@@ -1,9 +1,14 @@
 package dockertestsetup
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	dockertest "github.com/ory/dockertest/v3"
 	docker "github.com/ory/dockertest/v3/docker"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -11,6 +16,7 @@ type Options func(Config)
 
 type Container interface {
 	Up() Resource
+	UpContext(ctx context.Context) Resource
 	Config
 }
 
@@ -18,13 +24,32 @@ type Resource interface {
 	GetName() string
 	GetError() error
 	Cleanup() error
+	CleanupContext(ctx context.Context) error
 	Resource() *dockertest.Resource
 	Pool() *dockertest.Pool
 	Config() Config
+	ConnInfo() ConnInfo
+	InternalConnInfo() ConnInfo
+	Logs(ctx context.Context) (string, error)
+	Exec(ctx context.Context, cmd []string) (stdout, stderr string, exitCode int, err error)
 }
 
+// ConnInfo describes how to reach a started resource, from the host for
+// Resource.ConnInfo and from other containers for Resource.InternalConnInfo.
+type ConnInfo struct {
+	Host string
+	Port string
+	DSN  string
+}
+
+// LinkFunc is called right before a container is started with the resources
+// of all containers it depends on, keyed by container name.
+type LinkFunc func(c Config, deps map[string]Resource) error
+
 type DockerConfig interface {
 	Connect() (*dockertest.Resource, *dockertest.Pool, error)
+	ConnectContext(ctx context.Context) (*dockertest.Resource, *dockertest.Pool, error)
+	Release(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error
 
 	Name() string
 	Repository() string
@@ -41,6 +66,20 @@ type DockerConfig interface {
 	Cleanup() error
 	HostPort() string
 	ContainerPortId() string
+	DependsOn() []string
+	Links() []LinkFunc
+	Pool() *dockertest.Pool
+	Reuse() bool
+	Shared() bool
+	WaitStrategy() WaitStrategy
+	Copies() []Copy
+	Mounts() []Mount
+	Network() string
+	NetworkAliases() []string
+	Limits() Limits
+	PullPolicy() PullPolicy
+	RegistryAuth() *docker.AuthConfiguration
+	RegistryMirror() string
 
 	SetName(string)
 	SetRepository(string)
@@ -57,21 +96,131 @@ type DockerConfig interface {
 	SetCleanup(func() error)
 	SetHostPort(string)
 	SetContainerPortId(string)
+	SetDependsOn([]string)
+	SetLinks([]LinkFunc)
+	SetPool(*dockertest.Pool)
+	SetReuse(bool)
+	SetShared(bool)
+	SetWaitStrategy(WaitStrategy)
+	SetCopies([]Copy)
+	SetMounts([]Mount)
+	SetNetwork(string)
+	SetNetworkAliases([]string)
+	SetLimits(Limits)
+	SetPullPolicy(PullPolicy)
+	SetRegistryAuth(*docker.AuthConfiguration)
+	SetRegistryMirror(string)
 }
 
 type Config interface {
 	DockerConfig
 }
 
+type UpperOptions func(*DockerTestUpper)
+
 type DockerTestUpper struct {
 	Resources map[string]Resource
+
+	skipIfNoDocker bool
+	endpoint       string
+	poolMaxWait    time.Duration
+	pool           *dockertest.Pool
+	reaper         bool
+	reaperOptions  []Options
+	logDump        int
+	network        bool
+	networkName    string
+
+	mu      sync.Mutex
+	order   []string
+	cleaned map[string]bool
+}
+
+func NewUpper(opts ...UpperOptions) *DockerTestUpper {
+	dtu := &DockerTestUpper{
+		Resources: make(map[string]Resource),
+	}
+	for _, o := range opts {
+		o(dtu)
+	}
+	return dtu
+}
+
+// WithSkipIfNoDocker makes NewT, StartT and RunMain skip the tests instead of
+// failing them when no Docker daemon is reachable. The EnvSkipIfNoDocker
+// environment variable takes precedence over this option.
+func WithSkipIfNoDocker(skip bool) UpperOptions {
+	return func(dtu *DockerTestUpper) {
+		dtu.skipIfNoDocker = skip
+	}
+}
+
+// WithPool makes dtu hand pool to every container instead of connecting to
+// Docker on its own.
+func WithPool(pool *dockertest.Pool) UpperOptions {
+	return func(dtu *DockerTestUpper) {
+		dtu.pool = pool
+	}
+}
+
+// WithEndpoint sets the Docker endpoint the shared pool connects to. By default
+// it's taken from DOCKER_HOST or the OS specific default.
+func WithEndpoint(endpoint string) UpperOptions {
+	return func(dtu *DockerTestUpper) {
+		dtu.endpoint = endpoint
+	}
+}
+
+// WithPoolMaxWait overrides PoolMaxWait of every container started by dtu.
+func WithPoolMaxWait(d time.Duration) UpperOptions {
+	return func(dtu *DockerTestUpper) {
+		dtu.poolMaxWait = d
+	}
+}
+
+// Pool returns the pool shared by all containers of dtu, connecting to Docker
+// on first use.
+func (dtu *DockerTestUpper) Pool(ctx context.Context) (*dockertest.Pool, error) {
+	dtu.mu.Lock()
+	defer dtu.mu.Unlock()
+
+	if dtu.pool != nil {
+		return dtu.pool, nil
+	}
+
+	pool, err := dockertest.NewPool(dtu.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not create docker pool: %w", err)
+	}
+
+	if err = pool.Client.PingWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("could not connect to Docker: %w", err)
+	}
+	pool.MaxWait = dtu.poolMaxWait
+
+	dtu.pool = pool
+	return pool, nil
+}
+
+// Start starts the containers like New and returns the joined startup errors
+// of all resources of dtu.
+func (dtu *DockerTestUpper) Start(ctx context.Context, conts ...Container) error {
+	dtu.start(ctx, conts)
+	return dtu.Err()
 }
 
 func (dtu *DockerTestUpper) GetResourceByName(name string) (Resource, error) {
+	dtu.mu.Lock()
+	defer dtu.mu.Unlock()
 
 	r, ok := dtu.Resources[name]
 	if !ok {
-		return nil, fmt.Errorf("resource not find")
+		names := make([]string, 0, len(dtu.Resources))
+		for n := range dtu.Resources {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("resource %q not found, available: [%s]", name, strings.Join(names, ", "))
 	}
 	if r.GetError() != nil {
 		return nil, r.GetError()
@@ -80,18 +229,194 @@ func (dtu *DockerTestUpper) GetResourceByName(name string) (Resource, error) {
 	return r, nil
 }
 
+// Get looks the resource up by name like GetResourceByName and returns it as
+// the concrete resource type of its module, e.g. *postgres.Resource.
+func Get[T Resource](dtu *DockerTestUpper, name string) (T, error) {
+	var zero T
+
+	r, err := dtu.GetResourceByName(name)
+	if err != nil {
+		return zero, err
+	}
+
+	t, ok := r.(T)
+	if !ok {
+		return zero, fmt.Errorf("resource %q is %T, not %T", name, r, zero)
+	}
+
+	return t, nil
+}
+
+// Err returns the startup errors of all resources joined together, each one
+// prefixed with the name of the container it belongs to.
+func (dtu *DockerTestUpper) Err() error {
+	dtu.mu.Lock()
+	defer dtu.mu.Unlock()
+
+	var errs []error
+	for _, name := range dtu.order {
+		if err := dtu.Resources[name].GetError(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CleanupAll purges every resource in reverse startup order and then removes
+// the network created with WithNetwork. It keeps going when a resource fails
+// to clean up and returns the failures joined together. Resources that were
+// cleaned up successfully are skipped on later calls.
+func (dtu *DockerTestUpper) CleanupAll() error {
+	return dtu.CleanupAllContext(context.Background())
+}
+
+func (dtu *DockerTestUpper) CleanupAllContext(ctx context.Context) error {
+	dtu.mu.Lock()
+	defer dtu.mu.Unlock()
+
+	if dtu.cleaned == nil {
+		dtu.cleaned = make(map[string]bool, len(dtu.order))
+	}
+
+	var errs []error
+	for i := len(dtu.order) - 1; i >= 0; i-- {
+		name := dtu.order[i]
+		if dtu.cleaned[name] {
+			continue
+		}
+
+		if err := dtu.Resources[name].CleanupContext(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		dtu.cleaned[name] = true
+	}
+
+	if len(errs) == 0 {
+		if err := dtu.removeNetwork(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (dtu *DockerTestUpper) addResource(r Resource) {
+	dtu.mu.Lock()
+	defer dtu.mu.Unlock()
+
+	if dtu.Resources == nil {
+		dtu.Resources = make(map[string]Resource)
+	}
+	if _, ok := dtu.Resources[r.GetName()]; !ok {
+		dtu.order = append(dtu.order, r.GetName())
+	}
 	dtu.Resources[r.GetName()] = r
 }
 
-func New(conts ...Container) *DockerTestUpper { // ?
-	dtu := &DockerTestUpper{
-		Resources: make(map[string]Resource, 10),
+// New starts all containers and waits until every one of them is either
+// ready or failed. Containers are started concurrently, except that a
+// container declared with CfgDependsOn waits for its dependencies to become
+// ready first. Failures are reported through Err and GetResourceByName.
+func New(conts ...Container) *DockerTestUpper {
+	return NewContext(context.Background(), conts...)
+}
+
+// NewContext is like New, but stops waiting for the containers once ctx is
+// done. Containers that are still starting at that point are removed and
+// report the context error, containers that were already up keep running, so
+// callers still have to call CleanupAll.
+func NewContext(ctx context.Context, conts ...Container) *DockerTestUpper {
+	dtu := NewUpper()
+	dtu.start(ctx, conts)
+	return dtu
+}
+
+func (dtu *DockerTestUpper) start(ctx context.Context, conts []Container) {
+	if err := checkDependencies(conts); err != nil {
+		for _, c := range conts {
+			dtu.addResource(NewFailedResource(c.Name(), c, NewStartupError(c.Name(), PhaseConnect, ErrInvalidConfig, err)))
+		}
+		return
+	}
+
+	pool, err := dtu.Pool(ctx)
+	if err != nil {
+		for _, c := range conts {
+			dtu.addResource(NewFailedResource(c.Name(), c, NewStartupError(c.Name(), PhaseConnect, ErrDockerUnavailable, err)))
+		}
+		return
+	}
+
+	if dtu.reaper {
+		if err := StartReaper(ctx, pool, dtu.reaperOptions...); err != nil {
+			for _, c := range conts {
+				dtu.addResource(NewFailedResource(c.Name(), c, NewStartupError(c.Name(), PhaseConnect, ErrStartFailed, err)))
+			}
+			return
+		}
+	}
+
+	var network string
+	if dtu.network {
+		if network, err = dtu.createNetwork(ctx, pool); err != nil {
+			for _, c := range conts {
+				dtu.addResource(NewFailedResource(c.Name(), c, NewStartupError(c.Name(), PhaseConnect, ErrStartFailed, err)))
+			}
+			return
+		}
 	}
 
 	for _, c := range conts {
-		r := c.Up()
-		dtu.addResource(r)
+		if c.Pool() == nil {
+			c.SetPool(pool)
+		}
+		if network != "" {
+			c.SetNetwork(network)
+		}
+		if dtu.poolMaxWait > 0 {
+			c.SetPoolMaxWait(dtu.poolMaxWait)
+		}
 	}
-	return dtu
+
+	done := make(map[string]chan struct{}, len(conts))
+	for _, c := range conts {
+		done[c.Name()] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range conts {
+		wg.Add(1)
+		go func(c Container) {
+			defer wg.Done()
+			defer close(done[c.Name()])
+
+			for _, dep := range c.DependsOn() {
+				<-done[dep]
+			}
+			dtu.addResource(dtu.up(ctx, c))
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (dtu *DockerTestUpper) up(ctx context.Context, c Container) Resource {
+	deps := make(map[string]Resource, len(c.DependsOn()))
+	for _, dep := range c.DependsOn() {
+		dtu.mu.Lock()
+		r := dtu.Resources[dep]
+		dtu.mu.Unlock()
+
+		if r.GetError() != nil {
+			return NewFailedResource(c.Name(), c, NewStartupError(c.Name(), PhaseConnect, ErrDependencyFailed, fmt.Errorf("%s: %w", dep, r.GetError())))
+		}
+		deps[dep] = r
+	}
+
+	for _, link := range c.Links() {
+		if err := link(c, deps); err != nil {
+			return NewFailedResource(c.Name(), c, NewStartupError(c.Name(), PhaseConnect, ErrDependencyFailed, fmt.Errorf("couldn't link dependencies: %w", err)))
+		}
+	}
+
+	return c.UpContext(ctx)
 }
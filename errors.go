@@ -0,0 +1,66 @@
+package dockertestsetup
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrDockerUnavailable = errors.New("docker is unavailable")
+	ErrPullFailed        = errors.New("couldn't pull image")
+	ErrPortInUse         = errors.New("host port is already in use")
+	ErrNameInUse         = errors.New("container name is already in use")
+	ErrStartFailed       = errors.New("couldn't start container")
+	ErrReadinessTimeout  = errors.New("container didn't become ready")
+	ErrSetupFailed       = errors.New("couldn't set up container")
+	ErrInvalidConfig     = errors.New("invalid container configuration")
+	ErrDependencyFailed  = errors.New("dependency failed")
+)
+
+type Phase string
+
+const (
+	PhaseConnect Phase = "connect"
+	PhasePull    Phase = "pull"
+	PhaseRun     Phase = "run"
+	PhaseReady   Phase = "ready"
+	PhaseSetup   Phase = "setup"
+)
+
+// StartupError is returned by Connect and Up and reported by
+// Resource.GetError. It matches its Kind, one of the Err* variables above, as
+// well as the underlying error with errors.Is.
+type StartupError struct {
+	Container string
+	Phase     Phase
+	Kind      error
+	Err       error
+}
+
+func NewStartupError(container string, phase Phase, kind error, err error) *StartupError {
+	return &StartupError{
+		Container: container,
+		Phase:     phase,
+		Kind:      kind,
+		Err:       err,
+	}
+}
+
+func (e *StartupError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+func (e *StartupError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func runErrorKind(err error) error {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "port is already allocated") ||
+		strings.Contains(msg, "address already in use") ||
+		strings.Contains(msg, "ports are not available") {
+		return ErrPortInUse
+	}
+	return ErrStartFailed
+}
@@ -0,0 +1,124 @@
+package dockertestsetup
+
+import (
+	"context"
+	"errors"
+	dockertest "github.com/ory/dockertest/v3"
+	"io"
+	"testing"
+)
+
+func TestRunErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"port allocated", errors.New("API error (500): driver failed programming external connectivity on endpoint pg: Bind for 0.0.0.0:5432 failed: port is already allocated"), ErrPortInUse},
+		{"address in use", errors.New("listen tcp4 0.0.0.0:6379: bind: address already in use"), ErrPortInUse},
+		{"docker desktop", errors.New("Ports are not available: exposing port TCP 0.0.0.0:5432 -> 0.0.0.0:0: listen tcp 0.0.0.0:5432: bind: Only one usage of each socket address is normally permitted."), ErrPortInUse},
+		{"no such image", errors.New("no such image: postgres:99"), ErrStartFailed},
+		{"other", errors.New("OCI runtime create failed"), ErrStartFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runErrorKind(tt.err); got != tt.want {
+				t.Fatalf("runErrorKind(%q) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartupErrorIs(t *testing.T) {
+	err := error(NewStartupError("pg", PhaseRun, ErrPortInUse, io.ErrUnexpectedEOF))
+
+	tests := []struct {
+		name   string
+		target error
+		want   bool
+	}{
+		{"kind", ErrPortInUse, true},
+		{"underlying error", io.ErrUnexpectedEOF, true},
+		{"other kind", ErrStartFailed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(err, tt.target); got != tt.want {
+				t.Fatalf("errors.Is(%v, %v) = %v, want %v", err, tt.target, got, tt.want)
+			}
+		})
+	}
+
+	var se *StartupError
+	if !errors.As(err, &se) || se.Container != "pg" || se.Phase != PhaseRun {
+		t.Fatalf("errors.As didn't return the startup error: %#v", se)
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	failing := &testContainer{
+		Config: &DockerConfigImpl{name: "a"},
+		err:    NewStartupError("a", PhaseRun, ErrPortInUse, io.ErrUnexpectedEOF),
+	}
+
+	tests := []struct {
+		name      string
+		conts     []Container
+		container string
+		phase     Phase
+		kind      error
+		cause     error
+	}{
+		{
+			name:      "duplicate name",
+			conts:     []Container{newTestContainer("a"), newTestContainer("a")},
+			container: "a",
+			phase:     PhaseConnect,
+			kind:      ErrInvalidConfig,
+		},
+		{
+			name:      "unknown dependency",
+			conts:     []Container{newTestContainer("a", "b")},
+			container: "a",
+			phase:     PhaseConnect,
+			kind:      ErrInvalidConfig,
+		},
+		{
+			name:      "dependency cycle",
+			conts:     []Container{newTestContainer("a", "b"), newTestContainer("b", "a")},
+			container: "a",
+			phase:     PhaseConnect,
+			kind:      ErrInvalidConfig,
+		},
+		{
+			name:      "failed dependency",
+			conts:     []Container{failing, newTestContainer("b", "a")},
+			container: "b",
+			phase:     PhaseConnect,
+			kind:      ErrDependencyFailed,
+			cause:     ErrPortInUse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dtu := NewUpper(WithPool(&dockertest.Pool{}))
+			_ = dtu.Start(context.Background(), tt.conts...)
+
+			err := dtu.Resources[tt.container].GetError()
+
+			var se *StartupError
+			if !errors.As(err, &se) {
+				t.Fatalf("got %v, want a startup error", err)
+			}
+			if se.Container != tt.container || se.Phase != tt.phase || se.Kind != tt.kind {
+				t.Fatalf("got %s/%s/%v, want %s/%s/%v", se.Container, se.Phase, se.Kind, tt.container, tt.phase, tt.kind)
+			}
+			if tt.cause != nil && !errors.Is(se, tt.cause) {
+				t.Fatalf("%v doesn't wrap %v", se, tt.cause)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package dockertestsetup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+)
+
+// Exec runs cmd inside the container of resource and returns its output and
+// exit code. A non-zero exit code is not an error.
+func Exec(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource, cmd []string) (stdout, stderr string, exitCode int, err error) {
+	if resource == nil {
+		return "", "", 0, errors.New("container is not running")
+	}
+
+	exec, err := pool.Client.CreateExec(docker.CreateExecOptions{
+		Context:      ctx,
+		Container:    resource.Container.ID,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", "", 0, fmt.Errorf("couldn't create exec: %w", err)
+	}
+
+	var outBuf, errBuf bytes.Buffer
+	err = pool.Client.StartExec(exec.ID, docker.StartExecOptions{
+		Context:      ctx,
+		OutputStream: &outBuf,
+		ErrorStream:  &errBuf,
+	})
+	if err != nil {
+		return "", "", 0, fmt.Errorf("couldn't start exec: %w", err)
+	}
+
+	inspect, err := pool.Client.InspectExec(exec.ID)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("couldn't inspect exec: %w", err)
+	}
+
+	return outBuf.String(), errBuf.String(), inspect.ExitCode, nil
+}
@@ -0,0 +1,140 @@
+package generic
+
+import (
+	"context"
+	dockertestsetup "github.com/kitavrus/dockertestsetup/v7"
+	docker "github.com/ory/dockertest/v3/docker"
+	"path"
+	"strings"
+)
+
+func newDefaultConfig(repository, tag string) dockertestsetup.Config {
+	c := &GenericConfig{
+		DockerConfig: &dockertestsetup.DockerConfigImpl{},
+	}
+	c.SetRepository(repository)
+	c.SetTag(tag)
+	return c
+}
+
+// New returns a container for an arbitrary image. Ports the service listens
+// on are declared with CfgExposedPorts, everything else is configured with
+// the dockertestsetup.Cfg* options.
+func New(repository, tag string, opts ...dockertestsetup.Options) dockertestsetup.Container {
+	c := newDefaultConfig(repository, tag)
+	for _, o := range opts {
+		o(c)
+	}
+	c.(*GenericConfig).updateDockerConfig()
+	return &ContainerImpl{
+		Config: c,
+	}
+}
+
+type ContainerImpl struct {
+	dockertestsetup.Config
+}
+
+func (con *ContainerImpl) Up() dockertestsetup.Resource {
+	return con.UpContext(context.Background())
+}
+
+func (con *ContainerImpl) UpContext(ctx context.Context) dockertestsetup.Resource {
+
+	var (
+		genericConfig = con.Config.(*GenericConfig)
+		r             = &Resource{}
+	)
+
+	r.BaseResource = dockertestsetup.StartResource(ctx, con.Config, func(ctx context.Context, base *dockertestsetup.BaseResource) error {
+		r.endpoints = make(map[string]string, len(genericConfig.ExposedPorts))
+		for _, p := range genericConfig.ExposedPorts {
+			r.endpoints[p] = base.Resource().GetHostPort(p)
+		}
+		return nil
+	})
+
+	return r
+}
+
+type Resource struct {
+	*dockertestsetup.BaseResource
+	endpoints map[string]string
+}
+
+// Endpoint returns the host:port the exposed container port, e.g. "8080/tcp",
+// is mapped to on the host. InternalEndpoint returns the one other containers
+// use.
+func (r *Resource) Endpoint(port string) string {
+	return r.endpoints[port]
+}
+
+// Endpoints returns the host:port of every exposed port keyed by the
+// container port.
+func (r *Resource) Endpoints() map[string]string {
+	return r.endpoints
+}
+
+// CfgExposedPorts declares the container ports the service listens on, e.g.
+// "8080/tcp". The first one is reported by Resource.ConnInfo. Unless a wait
+// strategy is set, the container is ready once all of them accept
+// connections.
+func CfgExposedPorts(ports ...string) dockertestsetup.Options {
+	return func(c dockertestsetup.Config) {
+		c.(*GenericConfig).ExposedPorts = append(c.(*GenericConfig).ExposedPorts, ports...)
+	}
+}
+
+type GenericConfig struct {
+	dockertestsetup.DockerConfig
+	ExposedPorts []string
+}
+
+func (c *GenericConfig) updateDockerConfig() {
+
+	for i, p := range c.ExposedPorts {
+		if !strings.Contains(p, "/") {
+			c.ExposedPorts[i] = p + "/tcp"
+		}
+	}
+
+	if len(c.ContainerPortId()) == 0 && len(c.ExposedPorts) != 0 {
+		c.SetContainerPortId(c.ExposedPorts[0])
+	}
+
+	if len(c.PortBindings()) == 0 && len(c.ExposedPorts) != 0 {
+		// an empty host port lets Docker pick a free one
+		portBindings := make(map[docker.Port][]docker.PortBinding, len(c.ExposedPorts))
+		for _, p := range c.ExposedPorts {
+			portBindings[docker.Port(p)] = []docker.PortBinding{{}}
+		}
+		if len(c.HostPort()) != 0 && len(c.ContainerPortId()) != 0 {
+			portBindings[docker.Port(c.ContainerPortId())] = []docker.PortBinding{{HostPort: c.HostPort()}}
+		}
+		c.SetPortBindings(portBindings)
+	}
+
+	var waitStrategy dockertestsetup.WaitStrategy
+	if len(c.ExposedPorts) != 0 {
+		strategies := make([]dockertestsetup.WaitStrategy, 0, len(c.ExposedPorts))
+		for _, p := range c.ExposedPorts {
+			strategies = append(strategies, dockertestsetup.ForListeningPort(p))
+		}
+		waitStrategy = dockertestsetup.ForAll(strategies...)
+	}
+
+	dockertestsetup.ApplyDefaults(c.DockerConfig, dockertestsetup.ServiceDefaults{
+		Name:         defaultName(c.Repository()),
+		WaitStrategy: waitStrategy,
+	})
+}
+
+// defaultName turns the repository into a container name, e.g.
+// "mockserver/mockserver" into "mockserver".
+func defaultName(repository string) string {
+	name := path.Base(repository)
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
@@ -1,8 +1,9 @@
 module github.com/kitavrus/dockertestsetup/v7
 
-go 1.19
+go 1.20
 
 require (
+	github.com/cenkalti/backoff/v4 v4.1.3
 	github.com/golang-migrate/migrate/v4 v4.15.2
 	github.com/lib/pq v1.10.2
 	github.com/minio/minio-go/v7 v7.0.49
@@ -14,7 +15,6 @@ require (
 	github.com/Azure/go-ansiterm v0.0.0-20210617225240-d185dfc1b5a1 // indirect
 	github.com/Microsoft/go-winio v0.5.2 // indirect
 	github.com/Nvveen/Gotty v0.0.0-20120604004816-cd527374f1e5 // indirect
-	github.com/cenkalti/backoff/v4 v4.1.3 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/containerd/continuity v0.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
@@ -0,0 +1,70 @@
+package dockertestsetup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkDependencies makes sure that container names are unique, that every
+// declared dependency refers to one of the given containers and that the
+// dependencies do not form a cycle.
+func checkDependencies(conts []Container) error {
+	byName := make(map[string]Container, len(conts))
+	for _, c := range conts {
+		if _, ok := byName[c.Name()]; ok {
+			return fmt.Errorf("duplicate container name %q", c.Name())
+		}
+		byName[c.Name()] = c
+	}
+
+	for _, c := range conts {
+		for _, dep := range c.DependsOn() {
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("container %q depends on unknown container %q", c.Name(), dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(conts))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return fmt.Errorf("dependency cycle: %s", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range byName[name].DependsOn() {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, c := range conts {
+		if err := visit(c.Name()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package dockertestsetup
+
+import (
+	"context"
+	"testing"
+)
+
+// testContainer starts without Docker, it fails with err if set.
+type testContainer struct {
+	Config
+	err error
+}
+
+func (c *testContainer) Up() Resource {
+	return c.UpContext(context.Background())
+}
+
+func (c *testContainer) UpContext(context.Context) Resource {
+	return NewFailedResource(c.Name(), c, c.err)
+}
+
+func newTestContainer(name string, deps ...string) Container {
+	return &testContainer{Config: &DockerConfigImpl{name: name, dependsOn: deps}}
+}
+
+func TestCheckDependencies(t *testing.T) {
+	tests := []struct {
+		name  string
+		conts []Container
+		err   string
+	}{
+		{
+			name: "no dependencies",
+			conts: []Container{
+				newTestContainer("a"),
+				newTestContainer("b"),
+			},
+		},
+		{
+			name: "chain",
+			conts: []Container{
+				newTestContainer("app", "cache", "db"),
+				newTestContainer("cache", "db"),
+				newTestContainer("db"),
+			},
+		},
+		{
+			name: "duplicate name",
+			conts: []Container{
+				newTestContainer("db"),
+				newTestContainer("db"),
+			},
+			err: `duplicate container name "db"`,
+		},
+		{
+			name: "unknown dependency",
+			conts: []Container{
+				newTestContainer("app", "db"),
+			},
+			err: `container "app" depends on unknown container "db"`,
+		},
+		{
+			name: "self cycle",
+			conts: []Container{
+				newTestContainer("a", "a"),
+			},
+			err: "dependency cycle: a -> a",
+		},
+		{
+			name: "cycle",
+			conts: []Container{
+				newTestContainer("a", "b"),
+				newTestContainer("b", "a"),
+			},
+			err: "dependency cycle: a -> b -> a",
+		},
+		{
+			name: "cycle behind a dependency",
+			conts: []Container{
+				newTestContainer("app", "a"),
+				newTestContainer("a", "b"),
+				newTestContainer("b", "c"),
+				newTestContainer("c", "a"),
+			},
+			err: "dependency cycle: a -> b -> c -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDependencies(tt.conts)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("expected error %q, got nil", tt.err)
+			case tt.err != "" && err.Error() != tt.err:
+				t.Fatalf("expected error %q, got %q", tt.err, err)
+			}
+		})
+	}
+}
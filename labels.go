@@ -0,0 +1,194 @@
+package dockertestsetup
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	docker "github.com/ory/dockertest/v3/docker"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LabelLibrary    = "dockertestsetup.library"
+	LabelSession    = "dockertestsetup.session"
+	LabelModule     = "dockertestsetup.module"
+	LabelCreated    = "dockertestsetup.created"
+	LabelConfigHash = "dockertestsetup.config-hash"
+	LabelReap       = "dockertestsetup.reap"
+	LabelHost       = "dockertestsetup.host"
+	LabelPID        = "dockertestsetup.pid"
+
+	library = "github.com/kitavrus/dockertestsetup"
+)
+
+var (
+	sessionOnce sync.Once
+	sessionID   string
+)
+
+// SessionID returns a random ID that identifies the current test process.
+// Everything Connect creates is labeled with it under LabelSession.
+func SessionID() string {
+	sessionOnce.Do(func() {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		sessionID = hex.EncodeToString(b)
+	})
+	return sessionID
+}
+
+// SessionLabels returns the labels every container, network and volume
+// created in the current session carries.
+func SessionLabels() map[string]string {
+	return map[string]string{
+		LabelLibrary: library,
+		LabelSession: SessionID(),
+		LabelModule:  moduleName(),
+		LabelCreated: time.Now().UTC().Format(time.RFC3339),
+		LabelHost:    hostname(),
+		LabelPID:     strconv.Itoa(os.Getpid()),
+	}
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// sessionDead reports whether the test process that created a resource with
+// labels is known to have exited. The process can only be checked on the host
+// it ran on.
+func sessionDead(labels map[string]string) bool {
+	if labels[LabelSession] == SessionID() || labels[LabelHost] == "" || labels[LabelHost] != hostname() {
+		return false
+	}
+	pid, err := strconv.Atoi(labels[LabelPID])
+	if err != nil {
+		return false
+	}
+	return !processAlive(pid)
+}
+
+// sessionLabels returns SessionLabels for the container of c. Containers
+// that are meant to outlive the session, reused and shared ones, are not
+// marked for the reaper.
+func sessionLabels(c DockerConfig) map[string]string {
+	labels := SessionLabels()
+	if !c.Reuse() && !c.Shared() {
+		labels[LabelReap] = "true"
+	}
+	return labels
+}
+
+// moduleName returns the main module of the running binary, which for a test
+// binary is the module of the package under test.
+func moduleName() string {
+	if info, ok := debug.ReadBuildInfo(); ok && len(info.Main.Path) != 0 {
+		return info.Main.Path
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// ConfigHash returns a hash of everything in c that determines how its
+// container is created. Containers are labeled with it under LabelConfigHash.
+func ConfigHash(c DockerConfig) string {
+	b, _ := json.Marshal(struct {
+		Repository     string
+		Tag            string
+		Env            []string
+		Cmd            []string
+		Entrypoint     []string
+		WorkingDir     []string
+		PortBindings   map[docker.Port][]docker.PortBinding
+		AutoRemove     bool
+		RestartPolicy  docker.RestartPolicy
+		Mounts         []Mount
+		Limits         Limits
+		Copies         []string
+		Network        string
+		NetworkAliases []string
+	}{
+		Repository:     c.Repository(),
+		Tag:            c.Tag(),
+		Env:            c.Env(),
+		Cmd:            c.Cmd(),
+		Entrypoint:     c.Entrypoint(),
+		WorkingDir:     c.WorkingDir(),
+		PortBindings:   c.PortBindings(),
+		AutoRemove:     c.AutoRemove(),
+		RestartPolicy:  c.RestartPolicy(),
+		Mounts:         c.Mounts(),
+		Limits:         c.Limits(),
+		Copies:         copiesHash(c.Copies()),
+		Network:        hashedNetwork(c.Network()),
+		NetworkAliases: c.NetworkAliases(),
+	})
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// copiesHash hashes the content of every copy, so that changing e.g. a
+// copied postgresql.conf doesn't reuse a container created with the old one.
+func copiesHash(copies []Copy) []string {
+	hashes := make([]string, 0, len(copies))
+	for _, cp := range copies {
+		h := sha256.New()
+		h.Write([]byte(cp.Dir))
+		if cp.Hash != nil {
+			if err := cp.Hash(h); err != nil {
+				// the upload fails as well, but keep the hash stable
+				h.Write([]byte(err.Error()))
+			}
+		}
+		hashes = append(hashes, hex.EncodeToString(h.Sum(nil)))
+	}
+	return hashes
+}
+
+// hashedNetwork returns the network as it's hashed. The network WithNetwork
+// creates is named after the session, and reused containers join the one of
+// the current session anyway, so all of them hash the same.
+func hashedNetwork(network string) string {
+	if strings.HasPrefix(network, "dockertestsetup-"+SessionID()+"-") {
+		return "session"
+	}
+	return network
+}
+
+// checkRemovable returns an error unless the existing container with the
+// name of c may be removed to make room for a new one. Containers not created
+// by this library are never removed, neither are reused or shared containers
+// of a different configuration, which may belong to another project, nor
+// containers of test processes that are still running, e.g. another package
+// of the same go test ./... run.
+func checkRemovable(c DockerConfig, existing *docker.Container, hash string) error {
+	labels := existing.Config.Labels
+	switch {
+	case labels[LabelLibrary] != library:
+		return fmt.Errorf("container %q wasn't created by dockertestsetup", c.Name())
+	case labels[LabelReap] == "true" && (labels[LabelSession] == SessionID() || sessionDead(labels)):
+		return nil
+	case labels[LabelReap] == "true":
+		return fmt.Errorf("container %q belongs to another test process, give the containers of concurrent test processes different names or share them with CfgShared", c.Name())
+	case labels[LabelConfigHash] == hash && (c.Reuse() || c.Shared()):
+		return nil
+	default:
+		return fmt.Errorf("container %q is a reused or shared container with a different configuration, remove it with dockertestsetup prune -all", c.Name())
+	}
+}
+
+func isHealthy(c *docker.Container) bool {
+	if c == nil || !c.State.Running || c.State.Restarting || c.State.Dead {
+		return false
+	}
+	return c.State.Health.Status == "" || c.State.Health.Status == "healthy"
+}
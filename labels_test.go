@@ -0,0 +1,68 @@
+package dockertestsetup
+
+import (
+	docker "github.com/ory/dockertest/v3/docker"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+// deadPID returns the PID of a process that has already exited.
+func deadPID(t *testing.T) string {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return strconv.Itoa(cmd.Process.Pid)
+}
+
+func TestCheckRemovable(t *testing.T) {
+	const hash = "hash"
+
+	session := func(session, host, pid string, extra map[string]string) map[string]string {
+		labels := map[string]string{
+			LabelLibrary:    library,
+			LabelSession:    session,
+			LabelHost:       host,
+			LabelPID:        pid,
+			LabelConfigHash: hash,
+		}
+		for k, v := range extra {
+			labels[k] = v
+		}
+		return labels
+	}
+	reap := map[string]string{LabelReap: "true"}
+	self := strconv.Itoa(os.Getpid())
+	dead := deadPID(t)
+
+	tests := []struct {
+		name      string
+		config    *DockerConfigImpl
+		labels    map[string]string
+		removable bool
+	}{
+		{"not created by the library", &DockerConfigImpl{}, map[string]string{}, false},
+		{"own session", &DockerConfigImpl{}, session(SessionID(), hostname(), self, reap), true},
+		{"live foreign session", &DockerConfigImpl{}, session("other", hostname(), self, reap), false},
+		{"dead foreign session", &DockerConfigImpl{}, session("other", hostname(), dead, reap), true},
+		{"foreign session on another host", &DockerConfigImpl{}, session("other", "elsewhere", dead, reap), false},
+		{"foreign session without pid", &DockerConfigImpl{}, session("other", "", "", reap), false},
+		{"reused with same hash", &DockerConfigImpl{reuse: true}, session("other", hostname(), self, nil), true},
+		{"shared with same hash", &DockerConfigImpl{shared: true}, session("other", hostname(), self, nil), true},
+		{"reused with other hash", &DockerConfigImpl{reuse: true}, session("other", hostname(), self, map[string]string{LabelConfigHash: "other"}), false},
+		{"kept but not reused", &DockerConfigImpl{}, session("other", hostname(), dead, nil), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.name = "postgres"
+			existing := &docker.Container{Config: &docker.Config{Labels: tt.labels}}
+			err := checkRemovable(tt.config, existing, hash)
+			if removable := err == nil; removable != tt.removable {
+				t.Fatalf("removable = %v, want %v (err %v)", removable, tt.removable, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package dockertestsetup
+
+import (
+	docker "github.com/ory/dockertest/v3/docker"
+)
+
+// Limits constrains the resources a container may use. Zero values leave
+// the Docker defaults in place.
+type Limits struct {
+	// Memory limits the memory in bytes. Swap is limited to the same amount.
+	Memory int64
+	// CPUQuota is the CPU time in microseconds the container may use per
+	// CPUPeriod, e.g. 50000 per 100000 for half a CPU.
+	CPUQuota  int64
+	CPUPeriod int64
+	// CPUShares is the relative CPU weight, 1024 by default.
+	CPUShares int64
+	// ShmSize is the size of /dev/shm in bytes, 64MB by default.
+	ShmSize   int64
+	PidsLimit int64
+	Ulimits   []docker.ULimit
+}
+
+// CfgMemory limits the memory of the container to bytes.
+func CfgMemory(bytes int64) Options {
+	return func(c Config) {
+		l := c.Limits()
+		l.Memory = bytes
+		c.SetLimits(l)
+	}
+}
+
+// CfgCPUs limits the container to cpus CPUs, e.g. 0.5.
+func CfgCPUs(cpus float64) Options {
+	return CfgCPUQuota(int64(cpus*100000), 100000)
+}
+
+// CfgCPUQuota limits the container to quota microseconds of CPU time per
+// period.
+func CfgCPUQuota(quota, period int64) Options {
+	return func(c Config) {
+		l := c.Limits()
+		l.CPUQuota = quota
+		l.CPUPeriod = period
+		c.SetLimits(l)
+	}
+}
+
+func CfgCPUShares(shares int64) Options {
+	return func(c Config) {
+		l := c.Limits()
+		l.CPUShares = shares
+		c.SetLimits(l)
+	}
+}
+
+// CfgShmSize sets the size of /dev/shm, which Postgres needs for parallel
+// queries.
+func CfgShmSize(bytes int64) Options {
+	return func(c Config) {
+		l := c.Limits()
+		l.ShmSize = bytes
+		c.SetLimits(l)
+	}
+}
+
+func CfgPidsLimit(n int64) Options {
+	return func(c Config) {
+		l := c.Limits()
+		l.PidsLimit = n
+		c.SetLimits(l)
+	}
+}
+
+// CfgUlimit sets the ulimit name, e.g. "nofile".
+func CfgUlimit(name string, soft, hard int64) Options {
+	return func(c Config) {
+		l := c.Limits()
+		l.Ulimits = append(l.Ulimits, docker.ULimit{Name: name, Soft: soft, Hard: hard})
+		c.SetLimits(l)
+	}
+}
+
+func (l Limits) apply(hc *docker.HostConfig) {
+	hc.Memory = l.Memory
+	if l.Memory > 0 {
+		hc.MemorySwap = l.Memory
+	}
+	hc.CPUQuota = l.CPUQuota
+	hc.CPUPeriod = l.CPUPeriod
+	hc.CPUShares = l.CPUShares
+	hc.ShmSize = l.ShmSize
+	hc.PidsLimit = l.PidsLimit
+	hc.Ulimits = l.Ulimits
+}
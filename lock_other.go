@@ -0,0 +1,59 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package dockertestsetup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	lockRefreshInterval = 10 * time.Second
+	lockStaleAfter      = time.Minute
+)
+
+// lockFile falls back to creating a directory next to path, which is atomic
+// on every platform. Unlike flock the lock is not released when the process
+// dies, so the holder refreshes the directory's mtime while it holds the lock
+// and a lock that wasn't refreshed for a minute is considered stale.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	dir := path + ".d"
+
+	for {
+		err := os.Mkdir(dir, 0o755)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(dir); err == nil && time.Since(fi.ModTime()) > lockStaleAfter {
+			_ = os.Remove(dir)
+			continue
+		}
+		if err := sleepContext(ctx, 50*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(lockRefreshInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				_ = os.Chtimes(dir, now, now)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		_ = os.Remove(dir)
+	}, nil
+}
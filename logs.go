@@ -0,0 +1,190 @@
+package dockertestsetup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+	"io"
+	"strings"
+	"sync"
+)
+
+// LogBufferLines is the number of output lines kept per container once the
+// container itself is gone.
+var LogBufferLines = 1000
+
+// WithLogDump makes StartT print the last n lines of every container's output
+// with t.Log when the test fails, and RunMain print them to stderr when
+// starting fails or the tests fail.
+func WithLogDump(n int) UpperOptions {
+	return func(dtu *DockerTestUpper) {
+		dtu.logDump = n
+	}
+}
+
+// logBuffer keeps the last lines written to it.
+type logBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLogBuffer(size int) *logBuffer {
+	if size <= 0 {
+		size = 1
+	}
+	return &logBuffer{lines: make([]string, size)}
+}
+
+func (b *logBuffer) add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var sb strings.Builder
+	if b.full {
+		for _, l := range b.lines[b.next:] {
+			sb.WriteString(l)
+			sb.WriteByte('\n')
+		}
+	}
+	for _, l := range b.lines[:b.next] {
+		sb.WriteString(l)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// lineWriter splits a stream into lines and adds them to a logBuffer. Each
+// stream gets its own writer so that partial lines of stdout and stderr
+// don't get mixed up.
+type lineWriter struct {
+	buf     *logBuffer
+	pending []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.buf.add(strings.TrimSuffix(string(w.pending[:i]), "\r"))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	if len(w.pending) != 0 {
+		w.buf.add(string(w.pending))
+		w.pending = nil
+	}
+}
+
+// logCapture follows the output of a container until it is stopped.
+type logCapture struct {
+	buf    *logBuffer
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func captureLogs(pool *dockertest.Pool, resource *dockertest.Resource) *logCapture {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := &logCapture{
+		buf:    newLogBuffer(LogBufferLines),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	stdout := &lineWriter{buf: lc.buf}
+	stderr := &lineWriter{buf: lc.buf}
+
+	go func() {
+		defer close(lc.done)
+		_ = pool.Client.Logs(docker.LogsOptions{
+			Context:      ctx,
+			Container:    resource.Container.ID,
+			OutputStream: stdout,
+			ErrorStream:  stderr,
+			Stdout:       true,
+			Stderr:       true,
+			Follow:       true,
+		})
+		stdout.flush()
+		stderr.flush()
+	}()
+
+	return lc
+}
+
+func (lc *logCapture) stop() {
+	lc.cancel()
+	<-lc.done
+}
+
+// readLogs returns the complete output of a running container.
+func readLogs(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) (string, error) {
+	var out bytes.Buffer
+	err := pool.Client.Logs(docker.LogsOptions{
+		Context:      ctx,
+		Container:    resource.Container.ID,
+		OutputStream: &out,
+		ErrorStream:  &out,
+		Stdout:       true,
+		Stderr:       true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't read container logs: %w", err)
+	}
+	return out.String(), nil
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dumpLogs writes the last dtu.logDump lines of every resource to w.
+func (dtu *DockerTestUpper) dumpLogs(ctx context.Context, w io.Writer) {
+	if dtu.logDump <= 0 {
+		return
+	}
+
+	dtu.mu.Lock()
+	resources := make([]Resource, 0, len(dtu.order))
+	for _, name := range dtu.order {
+		resources = append(resources, dtu.Resources[name])
+	}
+	dtu.mu.Unlock()
+
+	for _, r := range resources {
+		logs, err := r.Logs(ctx)
+		if err != nil {
+			fmt.Fprintf(w, "--- %s: %v\n", r.GetName(), err)
+			continue
+		}
+		if len(logs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "--- last %d log lines of %s:\n%s\n", dtu.logDump, r.GetName(), lastLines(logs, dtu.logDump))
+	}
+}
@@ -0,0 +1,99 @@
+package dockertestsetup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogBuffer(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		lines []string
+		want  string
+	}{
+		{"empty", 3, nil, ""},
+		{"not full", 3, []string{"a", "b"}, "a\nb\n"},
+		{"exactly full", 3, []string{"a", "b", "c"}, "a\nb\nc\n"},
+		{"wrapped once", 3, []string{"a", "b", "c", "d"}, "b\nc\nd\n"},
+		{"wrapped twice", 3, []string{"a", "b", "c", "d", "e", "f", "g"}, "e\nf\ng\n"},
+		{"size one", 1, []string{"a", "b"}, "b\n"},
+		{"size zero keeps one", 0, []string{"a", "b"}, "b\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newLogBuffer(tt.size)
+			for _, l := range tt.lines {
+				b.add(l)
+			}
+			if got := b.String(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"single line", []string{"hello\n"}, "hello\n"},
+		{"split across writes", []string{"hel", "lo\nwor", "ld\n"}, "hello\nworld\n"},
+		{"crlf", []string{"a\r\nb\r\n"}, "a\nb\n"},
+		{"partial line is flushed", []string{"a\nb"}, "a\nb\n"},
+		{"empty lines", []string{"\n\n"}, "\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newLogBuffer(10)
+			w := &lineWriter{buf: b}
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			w.flush()
+			if got := b.String(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"fewer lines than n", "a\nb\n", 5, "a\nb"},
+		{"exactly n", "a\nb\nc\n", 3, "a\nb\nc"},
+		{"more lines than n", "a\nb\nc\nd\n", 2, "c\nd"},
+		{"no trailing newline", "a\nb\nc", 1, "c"},
+		{"empty", "", 3, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lastLines(tt.s, tt.n); got != tt.want {
+				t.Fatalf("lastLines(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogBufferKeepsLastLinesOfLongOutput(t *testing.T) {
+	b := newLogBuffer(100)
+	w := &lineWriter{buf: b}
+	for i := 0; i < 1000; i++ {
+		_, _ = w.Write([]byte(strings.Repeat("x", i%7) + "\n"))
+	}
+	if got := strings.Count(b.String(), "\n"); got != 100 {
+		t.Fatalf("buffer holds %d lines, want 100", got)
+	}
+}
@@ -0,0 +1,167 @@
+package dockertestsetup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+)
+
+// LabelRemoveWith marks a volume created for a mount with RemoveVolume. Its
+// value is the name of the container the volume is removed with.
+const LabelRemoveWith = "dockertestsetup.remove-with"
+
+type MountType string
+
+const (
+	MountBind   MountType = "bind"
+	MountVolume MountType = "volume"
+	MountTmpfs  MountType = "tmpfs"
+)
+
+// Mount is a bind mount, named volume or tmpfs mounted into the container.
+type Mount struct {
+	Type MountType
+	// Source is the host path of a bind mount or the name of a volume.
+	Source   string
+	Target   string
+	ReadOnly bool
+	// RemoveVolume removes the volume together with the container, unless
+	// it existed before the container was created.
+	RemoveVolume bool
+	// TmpfsSize limits a tmpfs in bytes, 0 means unlimited.
+	TmpfsSize int64
+}
+
+// CfgBindMount mounts the host path hostPath at containerPath.
+func CfgBindMount(hostPath, containerPath string, readOnly bool) Options {
+	return func(c Config) {
+		c.SetMounts(append(c.Mounts(), Mount{
+			Type:     MountBind,
+			Source:   hostPath,
+			Target:   containerPath,
+			ReadOnly: readOnly,
+		}))
+	}
+}
+
+// CfgVolume mounts the named volume name at containerPath. The volume is
+// created with the session labels if it doesn't exist. With remove a volume
+// created this way is removed together with the container, otherwise it's
+// kept, e.g. to cache data between runs. A volume that already existed is
+// never removed.
+func CfgVolume(name, containerPath string, remove bool) Options {
+	return func(c Config) {
+		c.SetMounts(append(c.Mounts(), Mount{
+			Type:         MountVolume,
+			Source:       name,
+			Target:       containerPath,
+			RemoveVolume: remove,
+		}))
+	}
+}
+
+// CfgTmpfs mounts a tmpfs of at most size bytes at containerPath, e.g. to keep
+// the Postgres data dir in memory. A size of 0 means unlimited.
+func CfgTmpfs(containerPath string, size int64) Options {
+	return func(c Config) {
+		c.SetMounts(append(c.Mounts(), Mount{
+			Type:      MountTmpfs,
+			Target:    containerPath,
+			TmpfsSize: size,
+		}))
+	}
+}
+
+func (c *DockerConfigImpl) hostMounts() []docker.HostMount {
+	mounts := make([]docker.HostMount, 0, len(c.Mounts()))
+	for _, m := range c.Mounts() {
+		hm := docker.HostMount{
+			Type:     string(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+		if m.Type == MountTmpfs && m.TmpfsSize > 0 {
+			hm.TempfsOptions = &docker.TempfsOptions{SizeBytes: m.TmpfsSize}
+		}
+		mounts = append(mounts, hm)
+	}
+	return mounts
+}
+
+// createVolumes creates the named volumes of c that don't exist yet and
+// returns the names of the ones it created. Volumes removed with the container
+// are labeled with LabelRemoveWith, and marked for the reaper as well.
+func (c *DockerConfigImpl) createVolumes(ctx context.Context, pool *dockertest.Pool) ([]string, error) {
+	var created []string
+	for _, m := range c.Mounts() {
+		if m.Type != MountVolume {
+			continue
+		}
+
+		if _, err := pool.Client.InspectVolume(m.Source); err == nil {
+			continue
+		}
+
+		labels := SessionLabels()
+		if m.RemoveVolume {
+			labels[LabelRemoveWith] = c.Name()
+			if !c.Reuse() && !c.Shared() {
+				labels[LabelReap] = "true"
+			}
+		}
+
+		if _, err := pool.Client.CreateVolume(docker.CreateVolumeOptions{
+			Context: ctx,
+			Name:    m.Source,
+			Labels:  labels,
+		}); err != nil {
+			_ = removeNamedVolumes(ctx, pool, created)
+			return nil, fmt.Errorf("couldn't create volume %s: %w", m.Source, err)
+		}
+		created = append(created, m.Source)
+	}
+	return created, nil
+}
+
+// removeVolumes removes the named volumes of c mounted with RemoveVolume that
+// were created for its container. Volumes that existed before are left alone.
+func (c *DockerConfigImpl) removeVolumes(ctx context.Context, pool *dockertest.Pool) error {
+	var names []string
+	for _, m := range c.Mounts() {
+		if m.Type != MountVolume || !m.RemoveVolume {
+			continue
+		}
+
+		v, err := pool.Client.InspectVolume(m.Source)
+		if err != nil || v.Labels[LabelLibrary] != library || v.Labels[LabelRemoveWith] != c.Name() {
+			continue
+		}
+		names = append(names, m.Source)
+	}
+	return removeNamedVolumes(ctx, pool, names)
+}
+
+func removeNamedVolumes(ctx context.Context, pool *dockertest.Pool, names []string) error {
+	var errs []error
+	for _, name := range names {
+		err := pool.Client.RemoveVolumeWithOptions(docker.RemoveVolumeOptions{
+			Context: ctx,
+			Name:    name,
+		})
+		if err != nil && !errors.Is(err, docker.ErrNoSuchVolume) {
+			errs = append(errs, fmt.Errorf("couldn't remove volume %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// purge removes the container of resource and the volumes that go with it.
+func (c *DockerConfigImpl) purge(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	if err := Purge(ctx, pool, resource); err != nil {
+		return err
+	}
+	return c.removeVolumes(ctx, pool)
+}
@@ -0,0 +1,169 @@
+package dockertestsetup
+
+import (
+	"context"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+	"net"
+	"sync/atomic"
+)
+
+var networkSeq atomic.Int64
+
+// WithNetwork makes dtu create a user-defined network for its containers, so
+// that they can reach each other by container name or by the aliases set with
+// CfgNetworkAliases. The network is removed by CleanupAll.
+func WithNetwork(network bool) UpperOptions {
+	return func(dtu *DockerTestUpper) {
+		dtu.network = network
+	}
+}
+
+// CfgNetwork attaches the container to the existing network name. Containers
+// started by an upper with WithNetwork join its network instead.
+func CfgNetwork(name string) Options {
+	return func(c Config) {
+		c.SetNetwork(name)
+	}
+}
+
+// CfgNetworkAliases adds names other containers on the network can reach the
+// container by, in addition to its container name.
+func CfgNetworkAliases(aliases ...string) Options {
+	return func(c Config) {
+		c.SetNetworkAliases(append(c.NetworkAliases(), aliases...))
+	}
+}
+
+// createNetwork creates the network of dtu, labeled like the containers of the
+// session.
+func (dtu *DockerTestUpper) createNetwork(ctx context.Context, pool *dockertest.Pool) (string, error) {
+	dtu.mu.Lock()
+	defer dtu.mu.Unlock()
+
+	if dtu.networkName != "" {
+		return dtu.networkName, nil
+	}
+
+	labels := SessionLabels()
+	labels[LabelReap] = "true"
+
+	name := fmt.Sprintf("dockertestsetup-%s-%d", SessionID(), networkSeq.Add(1))
+	if _, err := pool.Client.CreateNetwork(docker.CreateNetworkOptions{
+		Context: ctx,
+		Name:    name,
+		Driver:  "bridge",
+		Labels:  labels,
+	}); err != nil {
+		return "", fmt.Errorf("couldn't create network: %w", err)
+	}
+
+	dtu.networkName = name
+	return name, nil
+}
+
+// removeNetwork removes the network of dtu. The caller holds dtu.mu.
+func (dtu *DockerTestUpper) removeNetwork() error {
+	if dtu.networkName == "" {
+		return nil
+	}
+
+	if err := dtu.pool.Client.RemoveNetwork(dtu.networkName); err != nil {
+		if _, ok := err.(*docker.NoSuchNetwork); !ok {
+			return fmt.Errorf("couldn't remove network %s: %w", dtu.networkName, err)
+		}
+	}
+	dtu.networkName = ""
+	return nil
+}
+
+func (c *DockerConfigImpl) endpointsConfig() map[string]*docker.EndpointConfig {
+	endpoints := map[string]*docker.EndpointConfig{}
+	if c.Network() != "" {
+		endpoints[c.Network()] = &docker.EndpointConfig{
+			Aliases: c.NetworkAliases(),
+		}
+	}
+	return endpoints
+}
+
+// joinNetwork attaches a reused or shared container, which was created by an
+// earlier session, to the network of c.
+func (c *DockerConfigImpl) joinNetwork(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	if c.Network() == "" {
+		return nil
+	}
+	if _, ok := resource.Container.NetworkSettings.Networks[c.Network()]; ok {
+		return nil
+	}
+
+	err := pool.Client.ConnectNetwork(c.Network(), docker.NetworkConnectionOptions{
+		Context:        ctx,
+		Container:      resource.Container.ID,
+		EndpointConfig: &docker.EndpointConfig{Aliases: c.NetworkAliases()},
+	})
+	if err != nil {
+		return NewStartupError(c.Name(), PhaseRun, ErrStartFailed, fmt.Errorf("couldn't connect to network %s: %w", c.Network(), err))
+	}
+
+	container, err := pool.Client.InspectContainerWithContext(resource.Container.ID, ctx)
+	if err != nil {
+		return NewStartupError(c.Name(), PhaseRun, ErrStartFailed, err)
+	}
+	resource.Container = container
+	return nil
+}
+
+// leaveNetwork detaches a container that keeps running from the network of c,
+// so that the network can be removed.
+func (c *DockerConfigImpl) leaveNetwork(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	if c.Network() == "" {
+		return nil
+	}
+
+	err := pool.Client.DisconnectNetwork(c.Network(), docker.NetworkConnectionOptions{
+		Context:   ctx,
+		Container: resource.Container.ID,
+		Force:     true,
+	})
+	if err != nil {
+		if _, ok := err.(*docker.NoSuchNetworkOrContainer); !ok {
+			return fmt.Errorf("couldn't disconnect from network %s: %w", c.Network(), err)
+		}
+	}
+	return nil
+}
+
+// internalHost is how other containers reach the container: by its name on
+// its network or by its IP address on the default bridge.
+func internalHost(c Config, resource *dockertest.Resource) string {
+	if c.Network() == "" {
+		return resource.Container.NetworkSettings.IPAddress
+	}
+	return c.Name()
+}
+
+func internalConnInfo(c Config, resource *dockertest.Resource) ConnInfo {
+	if len(c.ContainerPortId()) == 0 {
+		return ConnInfo{}
+	}
+
+	host := internalHost(c, resource)
+	port := docker.Port(c.ContainerPortId()).Port()
+
+	return ConnInfo{
+		Host: host,
+		Port: port,
+		DSN:  net.JoinHostPort(host, port),
+	}
+}
+
+// InternalEndpoint returns the host:port other containers reach the container
+// port, e.g. "5432/tcp", at.
+func (r *BaseResource) InternalEndpoint(port string) string {
+	if r.resource == nil {
+		return ""
+	}
+	return net.JoinHostPort(internalHost(r.config, r.resource), docker.Port(port).Port())
+}
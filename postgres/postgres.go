@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	migrate "github.com/golang-migrate/migrate/v4"
@@ -8,20 +9,16 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	dockertestsetup "github.com/kitavrus/dockertestsetup/v7"
 	_ "github.com/lib/pq"
-	dockertest "github.com/ory/dockertest/v3"
-	docker "github.com/ory/dockertest/v3/docker"
-	"net"
 	"net/url"
-	"runtime"
-	"time"
 )
 
+const DefaultName = "postgres"
+
 func newDefaultConfig() dockertestsetup.Config {
 	const (
 		pgUser          = "postgres"
 		pgPassword      = "postgres_pass"
 		pgDb            = "postgres_dbname"
-		hostPort        = "5434"
 		containerPortId = "5432/tcp"
 		pathToMigrate   = "db/migrations/"
 	)
@@ -31,7 +28,6 @@ func newDefaultConfig() dockertestsetup.Config {
 		PgUser:            pgUser,
 		PgPassword:        pgPassword,
 		PgDB:              pgDb,
-		PgHostPort:        hostPort,
 		PgContainerPortId: containerPortId,
 		PgSSLMode:         "disable",
 		withMigrate:       false,
@@ -63,125 +59,90 @@ type ContainerImpl struct {
 }
 
 func (con *ContainerImpl) Up() dockertestsetup.Resource {
+	return con.UpContext(context.Background())
+}
+
+func (con *ContainerImpl) UpContext(ctx context.Context) dockertestsetup.Resource {
 
 	var (
-		db       *sql.DB
 		pgConfig = con.Config.(*PgConfig)
+		r        = &Resource{}
 	)
 
-	resource, pool, err := con.Config.Connect()
-	if err != nil {
-		return con.resourceWithError(fmt.Errorf("%w", err))
-	}
-
-	err = resource.Expire(con.Config.ResourceExpire())
-	if err != nil {
-		return con.resourceWithError(fmt.Errorf("%w", err))
-	}
-
-	dsn := &url.URL{
-		Scheme: "postgres",
-		User:   url.UserPassword(pgConfig.PgUser, pgConfig.PgPassword),
-		Path:   pgConfig.PgDB,
-	}
-
-	q := dsn.Query()
-	q.Add("sslmode", pgConfig.PgSSLMode)
-
-	dsn.RawQuery = q.Encode()
-
-	dsn.Host = resource.GetHostPort(con.Config.ContainerPortId())
-	if runtime.GOOS == "darwin" { // MacOS-specific
-		dsn.Host = net.JoinHostPort(resource.GetBoundIP(con.Config.ContainerPortId()), resource.GetPort(con.Config.ContainerPortId()))
-	}
-	pgConfig.PgDSN = dsn.String()
-
-	pool.MaxWait = con.Config.PoolMaxWait()
-	if err = pool.Retry(func() error {
-		db, err = sql.Open("postgres", dsn.String())
-		if err != nil {
-			return err
+	r.BaseResource = dockertestsetup.StartResource(ctx, con.Config, func(ctx context.Context, base *dockertestsetup.BaseResource) error {
+		dsn := &url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(pgConfig.PgUser, pgConfig.PgPassword),
+			Host:   base.ConnInfo().DSN,
+			Path:   pgConfig.PgDB,
 		}
-		return db.Ping()
-	}); err != nil {
-		return con.resourceWithError(fmt.Errorf("could not open postgres : %w", err))
-	}
 
-	pgConfig.cleanup = func() error {
-
-		if db != nil {
-			if err := db.Close(); err != nil {
-				return fmt.Errorf("Couldn't close DB: %w", err)
+		q := dsn.Query()
+		q.Add("sslmode", pgConfig.PgSSLMode)
+
+		dsn.RawQuery = q.Encode()
+		pgConfig.PgDSN = dsn.String()
+
+		if err := dockertestsetup.Retry(ctx, con.Config.PoolMaxWait(), func() error {
+			if r.DB == nil {
+				db, err := sql.Open("postgres", dsn.String())
+				if err != nil {
+					return err
+				}
+				r.DB = db
+				base.AddCleanup(func(context.Context) error {
+					if err := db.Close(); err != nil {
+						return fmt.Errorf("Couldn't close DB: %w", err)
+					}
+					return nil
+				})
 			}
+			return r.DB.PingContext(ctx)
+		}); err != nil {
+			return dockertestsetup.NewStartupError(con.Name(), dockertestsetup.PhaseReady, dockertestsetup.ErrReadinessTimeout, fmt.Errorf("could not open postgres : %w", err))
 		}
 
-		if resource != nil {
-			if err := pool.Purge(resource); err != nil {
-				return fmt.Errorf("Couldn't purge container: %w", err)
+		if pgConfig.withMigrate {
+			instance, err := migratepostgres.WithInstance(r.DB, &migratepostgres.Config{})
+			if err != nil {
+				return dockertestsetup.NewStartupError(con.Name(), dockertestsetup.PhaseSetup, dockertestsetup.ErrSetupFailed, fmt.Errorf("couldn't migrate with instance: %w", err))
 			}
-		}
 
-		return nil
-	}
+			m, err := migrate.NewWithDatabaseInstance("file://"+pgConfig.pathToMigrate, pgConfig.PgDB, instance)
 
-	if con.Config.(*PgConfig).withMigrate && db != nil {
-		instance, err := migratepostgres.WithInstance(db, &migratepostgres.Config{})
-		if err != nil {
-			return con.resourceWithError(fmt.Errorf("couldn't migrate with instance: %w", err))
-		}
-
-		m, err := migrate.NewWithDatabaseInstance("file://"+pgConfig.pathToMigrate, pgConfig.PgDB, instance)
+			if err != nil {
+				return dockertestsetup.NewStartupError(con.Name(), dockertestsetup.PhaseSetup, dockertestsetup.ErrSetupFailed, fmt.Errorf("couldn't migrate database instance: %w", err))
+			}
 
-		if err != nil {
-			return con.resourceWithError(fmt.Errorf("couldn't migrate database instance: %w", err))
+			if err = m.Up(); err != nil && err != migrate.ErrNoChange {
+				return dockertestsetup.NewStartupError(con.Name(), dockertestsetup.PhaseSetup, dockertestsetup.ErrSetupFailed, fmt.Errorf("couldnt' up migrate: %w", err))
+			}
 		}
 
-		if err = m.Up(); err != nil && err != migrate.ErrNoChange {
-			return con.resourceWithError(fmt.Errorf("couldnt' up migrate: %w", err))
-		}
-	}
+		ci := base.ConnInfo()
+		ci.DSN = pgConfig.PgDSN
+		base.SetConnInfo(ci)
 
-	return &Resource{
-		Name:     con.Name(),
-		DB:       db,
-		resource: resource,
-		cleanup:  pgConfig.cleanup,
-		error:    nil,
-		config:   con.Config,
-	}
-}
+		internal := base.InternalConnInfo()
+		dsn.Host = internal.DSN
+		internal.DSN = dsn.String()
+		base.SetInternalConnInfo(internal)
 
-type Resource struct {
-	Name     string
-	DB       *sql.DB
-	resource *dockertest.Resource
-	pool     *dockertest.Pool
-	cleanup  func() error
-	error    error
-	config   dockertestsetup.Config
-}
-
-func (r *Resource) GetName() string {
-	return r.Name
-}
-
-func (r *Resource) GetError() error {
-	return r.error
-}
+		return nil
+	})
 
-func (r *Resource) Cleanup() error {
-	return r.cleanup()
+	return r
 }
 
-func (r *Resource) Resource() *dockertest.Resource {
-	return r.resource
+type Resource struct {
+	*dockertestsetup.BaseResource
+	DB *sql.DB
 }
 
-func (r *Resource) Pool() *dockertest.Pool {
-	return r.pool
-}
-func (r *Resource) Config() dockertestsetup.Config {
-	return r.config
+// From returns the Postgres resource started under DefaultName. Use
+// dockertestsetup.Get for containers started with a custom name.
+func From(dtu *dockertestsetup.DockerTestUpper) (*Resource, error) {
+	return dockertestsetup.Get[*Resource](dtu, DefaultName)
 }
 
 func CfgPgUser(u string) dockertestsetup.Options {
@@ -223,14 +184,6 @@ func CfgMigrate() dockertestsetup.Options {
 	}
 }
 
-func (con *ContainerImpl) resourceWithError(err error) dockertestsetup.Resource {
-	return &Resource{
-		Name:    con.Name(),
-		cleanup: con.Cleanup,
-		error:   err,
-	}
-}
-
 type PgConfig struct {
 	dockertestsetup.DockerConfig
 	PgUser            string
@@ -242,26 +195,10 @@ type PgConfig struct {
 	PgDSN             string
 	withMigrate       bool
 	pathToMigrate     string
-	cleanup           func() error
 }
 
 func (c *PgConfig) updateDockerConfig() {
 
-	var name = "postgres"
-	if len(c.Name()) != 0 {
-		name = c.Name()
-	}
-
-	var repository = "postgres"
-	if len(c.Repository()) != 0 {
-		repository = c.Repository()
-	}
-
-	var tag = "14.7-alpine3.17"
-	if len(c.Tag()) != 0 {
-		tag = c.Tag()
-	}
-
 	var pgUser = "postgres_user"
 	if len(c.PgUser) != 0 {
 		pgUser = c.PgUser
@@ -277,104 +214,24 @@ func (c *PgConfig) updateDockerConfig() {
 		pgDb = c.PgDB
 	}
 
-	var hostPort = "5434"
 	if len(c.PgHostPort) != 0 {
-		hostPort = c.PgHostPort
+		c.SetHostPort(c.PgHostPort)
 	}
 
-	var containerPortId docker.Port = "5432/tcp"
 	if len(c.PgContainerPortId) != 0 {
-		containerPortId = docker.Port(c.PgContainerPortId)
+		c.SetContainerPortId(c.PgContainerPortId)
 	}
 
-	var env []string
-	if len(c.Env()) != 0 {
-		env = c.Env()
-	} else {
-		env = []string{
+	dockertestsetup.ApplyDefaults(c.DockerConfig, dockertestsetup.ServiceDefaults{
+		Name:       DefaultName,
+		Repository: "postgres",
+		Tag:        "14.7-alpine3.17",
+		Env: []string{
 			fmt.Sprintf("POSTGRES_USER=%s", pgUser),
 			fmt.Sprintf("POSTGRES_PASSWORD=%s", pgPassword),
 			fmt.Sprintf("POSTGRES_DB=%s", pgDb),
 			"listen_addresses = '*'",
-		}
-	}
-
-	var cmd []string
-	if len(c.Cmd()) != 0 {
-		cmd = c.Cmd()
-	}
-
-	var entrypoint []string
-	if len(c.Entrypoint()) != 0 {
-		entrypoint = c.Entrypoint()
-	}
-
-	var workingDir []string
-	if len(c.WorkingDir()) != 0 {
-		workingDir = c.WorkingDir()
-	}
-
-	var autoRemove bool
-	if c.AutoRemove() {
-		autoRemove = c.AutoRemove()
-	}
-
-	var resourceExpire uint
-	if c.ResourceExpire() > 0 {
-		resourceExpire = c.ResourceExpire()
-	} else {
-		resourceExpire = 60
-	}
-
-	var poolMaxWait time.Duration
-	if c.PoolMaxWait() > 0 {
-		poolMaxWait = c.PoolMaxWait()
-	} else {
-		poolMaxWait = 50 * time.Second
-	}
-
-	var restartPolicy docker.RestartPolicy
-	if c.RestartPolicy() != restartPolicy {
-		restartPolicy = c.RestartPolicy()
-	} else {
-		restartPolicy = docker.RestartPolicy{
-			Name: "no",
-		}
-	}
-
-	var portBindings map[docker.Port][]docker.PortBinding
-	if len(c.PortBindings()) != 0 {
-		portBindings = c.PortBindings()
-	} else {
-		portBindings = map[docker.Port][]docker.PortBinding{
-			containerPortId: {{HostPort: hostPort}},
-		}
-	}
-
-	var cleanup func() error
-	if c.cleanup != nil {
-		cleanup = c.cleanup
-	} else {
-		cleanup = func() error { return nil }
-	}
-
-	dockerConfig := dockertestsetup.NewDockerConfig(
-		name,
-		repository,
-		tag,
-		env,
-		cmd,
-		entrypoint,
-		workingDir,
-		autoRemove,
-		resourceExpire,
-		poolMaxWait,
-		restartPolicy,
-		portBindings,
-		cleanup,
-		hostPort,
-		string(containerPortId),
-	)
-
-	c.DockerConfig = dockerConfig
+		},
+		ContainerPortId: "5432/tcp",
+	})
 }
@@ -0,0 +1,20 @@
+//go:build !unix && !windows
+
+package dockertestsetup
+
+import (
+	"errors"
+	"os"
+	"strconv"
+)
+
+// processAlive looks the process up in /proc, e.g. on Plan 9. Where there is
+// no /proc the process is considered alive, so a shared container is only
+// purged by the process that created it or by prune.
+func processAlive(pid int) bool {
+	if _, err := os.Stat("/proc"); err != nil {
+		return true
+	}
+	_, err := os.Stat("/proc/" + strconv.Itoa(pid))
+	return !errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,171 @@
+package dockertestsetup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Leftover is a container, network or volume created by this library.
+type Leftover struct {
+	Kind    string
+	ID      string
+	Name    string
+	Session string
+	Module  string
+	Created time.Time
+	// Kept is set for containers created with CfgReuse and volumes that
+	// aren't removed with their container. They outlive their session on
+	// purpose.
+	Kept bool
+	// InUse is set for shared containers that live test processes use.
+	InUse bool
+}
+
+// Leftovers lists the containers, networks and volumes labeled with
+// LabelLibrary that were created at least olderThan ago.
+func Leftovers(ctx context.Context, pool *dockertest.Pool, olderThan time.Duration) ([]Leftover, error) {
+	var leftovers []Leftover
+
+	containers, err := pool.Client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {LabelLibrary}},
+		Context: ctx,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list containers: %w", err)
+	}
+	inUse, unknown := sharedInUse()
+	for _, c := range containers {
+		created := time.Unix(c.Created, 0)
+		l := newLeftover("container", c.ID, strings.TrimPrefix(firstOf(c.Names), "/"), c.Labels, created)
+		l.Kept = l.Kept && c.Labels[LabelShared] != "true"
+		l.InUse = inUse[c.ID] || (unknown && c.Labels[LabelShared] == "true")
+		leftovers = append(leftovers, l)
+	}
+
+	networks, err := pool.Client.FilteredListNetworks(docker.NetworkFilterOpts{
+		"label": {LabelLibrary: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list networks: %w", err)
+	}
+	for _, n := range networks {
+		leftovers = append(leftovers, newLeftover("network", n.ID, n.Name, n.Labels, time.Time{}))
+	}
+
+	volumes, err := pool.Client.ListVolumes(docker.ListVolumesOptions{
+		Filters: map[string][]string{"label": {LabelLibrary}},
+		Context: ctx,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list volumes: %w", err)
+	}
+	for _, v := range volumes {
+		leftovers = append(leftovers, newLeftover("volume", v.Name, v.Name, v.Labels, time.Time{}))
+	}
+
+	old := leftovers[:0]
+	for _, l := range leftovers {
+		if time.Since(l.Created) >= olderThan {
+			old = append(old, l)
+		}
+	}
+
+	return old, nil
+}
+
+// Prune removes the leftovers returned by Leftovers and returns the ones it
+// removed. Containers are removed first so that their networks and volumes
+// are no longer in use. Kept and in use leftovers are only removed with all.
+func Prune(ctx context.Context, pool *dockertest.Pool, olderThan time.Duration, all bool) ([]Leftover, error) {
+	leftovers, err := Leftovers(ctx, pool, olderThan)
+	if err != nil {
+		return nil, err
+	}
+
+	if !all {
+		removable := leftovers[:0]
+		for _, l := range leftovers {
+			if !l.Kept && !l.InUse {
+				removable = append(removable, l)
+			}
+		}
+		leftovers = removable
+	}
+
+	var (
+		removed []Leftover
+		errs    []error
+	)
+	for _, l := range leftovers {
+		var err error
+		switch l.Kind {
+		case "container":
+			err = pool.Client.RemoveContainer(docker.RemoveContainerOptions{ID: l.ID, Force: true, RemoveVolumes: true, Context: ctx})
+		case "network":
+			err = pool.Client.RemoveNetwork(l.ID)
+		case "volume":
+			err = pool.Client.RemoveVolumeWithOptions(docker.RemoveVolumeOptions{Name: l.ID, Force: true, Context: ctx})
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", l.Kind, l.Name, err))
+			continue
+		}
+		removed = append(removed, l)
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+func newLeftover(kind, id, name string, labels map[string]string, created time.Time) Leftover {
+	if t, err := time.Parse(time.RFC3339, labels[LabelCreated]); err == nil {
+		created = t
+	}
+	return Leftover{
+		Kind:    kind,
+		ID:      id,
+		Name:    name,
+		Session: labels[LabelSession],
+		Module:  labels[LabelModule],
+		Created: created,
+		Kept:    labels[LabelReap] != "true",
+	}
+}
+
+// sharedInUse returns the IDs of the shared containers that live processes
+// on this machine have registered in their shared state files. unknown is set
+// if a state file couldn't be read, in which case any shared container may be
+// in use.
+func sharedInUse() (inUse map[string]bool, unknown bool) {
+	inUse = make(map[string]bool)
+
+	_, statePath, err := sharedPaths("*")
+	if err != nil {
+		return inUse, true
+	}
+	paths, _ := filepath.Glob(statePath)
+	for _, p := range paths {
+		state, err := readSharedState(p)
+		if err != nil {
+			unknown = true
+			continue
+		}
+		if len(state.PIDs) > 0 {
+			inUse[state.ContainerID] = true
+		}
+	}
+	return inUse, unknown
+}
+
+func firstOf(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
@@ -0,0 +1,203 @@
+package dockertestsetup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvRegistryMirror sets the registry mirror of every container that doesn't
+// set one with CfgRegistryMirror, e.g.
+// DOCKERTESTSETUP_REGISTRY_MIRROR=mirror.example.com on CI.
+const EnvRegistryMirror = "DOCKERTESTSETUP_REGISTRY_MIRROR"
+
+const dockerHubRegistry = "docker.io"
+
+type PullPolicy int
+
+const (
+	// PullIfMissing pulls the image only if it isn't present locally.
+	PullIfMissing PullPolicy = iota
+	// PullAlways pulls the image before every container is created.
+	PullAlways
+	// PullNever never pulls and fails if the image isn't present locally.
+	PullNever
+)
+
+// CfgPullPolicy sets when the image is pulled, PullIfMissing by default.
+func CfgPullPolicy(p PullPolicy) Options {
+	return func(c Config) {
+		c.SetPullPolicy(p)
+	}
+}
+
+// CfgRegistryAuth sets the credentials the image is pulled with. Without it
+// the credentials for the registry are read from ~/.docker/config.json, or
+// the file DOCKER_CONFIG points to. Credential helpers configured there with
+// credsStore or credHelpers, e.g. by Docker Desktop, aren't run; the image is
+// pulled anonymously then, and a failed pull mentions the skipped helper.
+func CfgRegistryAuth(username, password string) Options {
+	return func(c Config) {
+		c.SetRegistryAuth(&docker.AuthConfiguration{
+			Username: username,
+			Password: password,
+		})
+	}
+}
+
+// CfgRegistryMirror pulls images from Docker Hub through mirror, a registry
+// host optionally followed by a path, e.g. "mirror.example.com/dockerhub".
+// "postgres" becomes "mirror.example.com/dockerhub/library/postgres", images
+// of other registries are left alone.
+func CfgRegistryMirror(mirror string) Options {
+	return func(c Config) {
+		c.SetRegistryMirror(mirror)
+	}
+}
+
+// pullRepository returns the repository the image of c is pulled from and
+// created with, after rewriting it for the registry mirror.
+func (c *DockerConfigImpl) pullRepository() string {
+	mirror := c.RegistryMirror()
+	if mirror == "" {
+		mirror = os.Getenv(EnvRegistryMirror)
+	}
+	return mirrorRepository(mirror, c.Repository())
+}
+
+func mirrorRepository(mirror, repository string) string {
+	mirror = strings.TrimSuffix(mirror, "/")
+	if mirror == "" || registryHost(repository) != dockerHubRegistry {
+		return repository
+	}
+
+	repository = strings.TrimPrefix(repository, dockerHubRegistry+"/")
+	repository = strings.TrimPrefix(repository, "index.docker.io/")
+	if !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return mirror + "/" + repository
+}
+
+// registryHost returns the registry the repository belongs to, following the
+// rules of the docker CLI: the first path component is a registry host if it
+// contains a dot or a port, or is localhost.
+func registryHost(repository string) string {
+	i := strings.IndexByte(repository, '/')
+	if i < 0 {
+		return dockerHubRegistry
+	}
+
+	host := repository[:i]
+	if strings.ContainsAny(host, ".:") || host == "localhost" {
+		if host == "index.docker.io" {
+			return dockerHubRegistry
+		}
+		return host
+	}
+	return dockerHubRegistry
+}
+
+// pullAuth returns the credentials to pull repository with.
+func (c *DockerConfigImpl) pullAuth(repository string) docker.AuthConfiguration {
+	if c.RegistryAuth() != nil {
+		return *c.RegistryAuth()
+	}
+	return dockerCfgAuth(repository)
+}
+
+// dockerCfgAuth returns the credentials for the registry of repository from
+// the docker CLI config.
+func dockerCfgAuth(repository string) docker.AuthConfiguration {
+	auths, err := docker.NewAuthConfigurationsFromDockerCfg()
+	if err != nil {
+		return docker.AuthConfiguration{}
+	}
+
+	host := registryHost(repository)
+	for server, auth := range auths.Configs {
+		if registryServer(server) == host {
+			return auth
+		}
+	}
+	return docker.AuthConfiguration{}
+}
+
+// credentialHelper returns the credential helper the docker CLI config names
+// for the registry of repository, if any.
+func credentialHelper(repository string) string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return ""
+	}
+
+	var cfg struct {
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return ""
+	}
+
+	host := registryHost(repository)
+	for server, helper := range cfg.CredHelpers {
+		if registryServer(server) == host {
+			return helper
+		}
+	}
+	return cfg.CredsStore
+}
+
+// registryServer returns the host of a server as it's keyed in the docker CLI
+// config, e.g. https://index.docker.io/v1/.
+func registryServer(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server = strings.SplitN(server, "/", 2)[0]
+	if server == "index.docker.io" {
+		server = dockerHubRegistry
+	}
+	return server
+}
+
+func (c *DockerConfigImpl) pullImage(ctx context.Context, pool *dockertest.Pool) error {
+	if c.PullPolicy() != PullAlways {
+		if _, err := pool.Client.InspectImage(c.image()); err == nil {
+			return nil
+		} else if !errors.Is(err, docker.ErrNoSuchImage) {
+			return err
+		}
+
+		if c.PullPolicy() == PullNever {
+			return fmt.Errorf("image %s is not present and the pull policy is never", c.image())
+		}
+	}
+
+	repository := c.pullRepository()
+	auth := c.pullAuth(repository)
+	err := pool.Client.PullImage(docker.PullImageOptions{
+		Repository: repository,
+		Tag:        c.imageTag(),
+		Context:    ctx,
+	}, auth)
+	if err != nil && auth == (docker.AuthConfiguration{}) {
+		if helper := credentialHelper(repository); helper != "" {
+			return fmt.Errorf("%w (pulled anonymously, credential helper %q isn't supported, use CfgRegistryAuth)", err, helper)
+		}
+	}
+	return err
+}
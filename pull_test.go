@@ -0,0 +1,129 @@
+package dockertestsetup
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorRepository(t *testing.T) {
+	tests := []struct {
+		mirror     string
+		repository string
+		want       string
+	}{
+		{"", "postgres", "postgres"},
+		{"mirror.example.com", "postgres", "mirror.example.com/library/postgres"},
+		{"mirror.example.com/", "postgres", "mirror.example.com/library/postgres"},
+		{"mirror.example.com/dockerhub", "redis", "mirror.example.com/dockerhub/library/redis"},
+		{"mirror.example.com", "minio/minio", "mirror.example.com/minio/minio"},
+		{"mirror.example.com", "docker.io/library/postgres", "mirror.example.com/library/postgres"},
+		{"mirror.example.com", "docker.io/postgres", "mirror.example.com/library/postgres"},
+		{"mirror.example.com", "docker.io/minio/minio", "mirror.example.com/minio/minio"},
+		{"mirror.example.com", "index.docker.io/minio/minio", "mirror.example.com/minio/minio"},
+		{"mirror.example.com", "ghcr.io/org/image", "ghcr.io/org/image"},
+		{"mirror.example.com", "localhost:5000/image", "localhost:5000/image"},
+		{"mirror.example.com", "localhost/image", "localhost/image"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mirror+" "+tt.repository, func(t *testing.T) {
+			if got := mirrorRepository(tt.mirror, tt.repository); got != tt.want {
+				t.Fatalf("mirrorRepository(%q, %q) = %q, want %q", tt.mirror, tt.repository, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := []struct {
+		repository string
+		want       string
+	}{
+		{"postgres", "docker.io"},
+		{"minio/minio", "docker.io"},
+		{"docker.io/library/postgres", "docker.io"},
+		{"index.docker.io/minio/minio", "docker.io"},
+		{"ghcr.io/org/image", "ghcr.io"},
+		{"registry.example.com:5000/image", "registry.example.com:5000"},
+		{"localhost/image", "localhost"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.repository, func(t *testing.T) {
+			if got := registryHost(tt.repository); got != tt.want {
+				t.Fatalf("registryHost(%q) = %q, want %q", tt.repository, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerCfgAuth(t *testing.T) {
+	auth := func(user, pass string) string {
+		return base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+	}
+
+	dir := t.TempDir()
+	config := `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + auth("hub", "hubpass") + `"},
+		"mirror.example.com": {"auth": "` + auth("mirror", "mirrorpass") + `"},
+		"https://ghcr.io": {"auth": "` + auth("gh", "ghpass") + `"}
+	}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("HOME", t.TempDir())
+
+	tests := []struct {
+		repository string
+		username   string
+	}{
+		{"postgres", "hub"},
+		{"minio/minio", "hub"},
+		{"mirror.example.com/library/postgres", "mirror"},
+		{"ghcr.io/org/image", "gh"},
+		{"quay.io/org/image", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.repository, func(t *testing.T) {
+			if got := dockerCfgAuth(tt.repository).Username; got != tt.username {
+				t.Fatalf("dockerCfgAuth(%q).Username = %q, want %q", tt.repository, got, tt.username)
+			}
+		})
+	}
+}
+
+func TestCredentialHelper(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		repository string
+		helper     string
+	}{
+		{"no helper", `{"auths": {}}`, "postgres", ""},
+		{"creds store", `{"credsStore": "desktop"}`, "postgres", "desktop"},
+		{"registry helper", `{"credHelpers": {"gcr.io": "gcloud"}}`, "gcr.io/org/image", "gcloud"},
+		{"registry helper of another registry", `{"credHelpers": {"gcr.io": "gcloud"}}`, "postgres", ""},
+		{"registry helper before creds store", `{"credsStore": "desktop", "credHelpers": {"https://index.docker.io/v1/": "pass"}}`, "postgres", "pass"},
+		{"no config", "", "postgres", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.config != "" {
+				if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(tt.config), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("DOCKER_CONFIG", dir)
+
+			if got := credentialHelper(tt.repository); got != tt.helper {
+				t.Fatalf("credentialHelper(%q) = %q, want %q", tt.repository, got, tt.helper)
+			}
+		})
+	}
+}
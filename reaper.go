@@ -0,0 +1,126 @@
+package dockertestsetup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	reaperRepository = "testcontainers/ryuk"
+	reaperTag        = "0.5.1"
+	reaperPort       = "8080/tcp"
+)
+
+var (
+	reaperMu   sync.Mutex
+	reaperConn net.Conn
+)
+
+// WithReaper starts a reaper sidecar before the first container. The reaper
+// removes every container, network and volume labeled with the current
+// SessionID and LabelReap shortly after the test process exits or gets
+// killed, i.e. once its connection to the reaper drops.
+func WithReaper(reaper bool) UpperOptions {
+	return func(dtu *DockerTestUpper) {
+		dtu.reaper = reaper
+	}
+}
+
+// WithReaperOptions configures how the reaper image is pulled, e.g. with
+// CfgPullPolicy, CfgRegistryAuth and CfgRegistryMirror, or CfgRepository
+// for a copy of the image in a private registry.
+func WithReaperOptions(opts ...Options) UpperOptions {
+	return func(dtu *DockerTestUpper) {
+		dtu.reaperOptions = append(dtu.reaperOptions, opts...)
+	}
+}
+
+// StartReaper starts the reaper sidecar on pool and registers the current
+// session with it. It does nothing if the session is already registered. The
+// image is pulled like the image of any container configured with opts.
+func StartReaper(ctx context.Context, pool *dockertest.Pool, opts ...Options) error {
+	reaperMu.Lock()
+	defer reaperMu.Unlock()
+
+	if reaperConn != nil {
+		return nil
+	}
+
+	rc := &DockerConfigImpl{
+		repository: reaperRepository,
+		tag:        reaperTag,
+	}
+	for _, o := range opts {
+		o(rc)
+	}
+
+	if err := rc.pullImage(ctx, pool); err != nil {
+		return fmt.Errorf("couldn't pull reaper image: %w", err)
+	}
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Name:         "dockertestsetup-reaper-" + SessionID(),
+		Repository:   rc.pullRepository(),
+		Tag:          rc.imageTag(),
+		ExposedPorts: []string{reaperPort},
+		Mounts:       []string{dockerSocket(pool) + ":/var/run/docker.sock"},
+		Labels:       SessionLabels(),
+	}, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't start reaper: %w", err)
+	}
+
+	var conn net.Conn
+	err = Retry(ctx, 30*time.Second, func() error {
+		c, err := (&net.Dialer{}).DialContext(ctx, "tcp", resource.GetHostPort(reaperPort))
+		if err != nil {
+			return err
+		}
+
+		filter := url.Values{"label": {LabelSession + "=" + SessionID(), LabelReap + "=true"}}.Encode()
+		if _, err := fmt.Fprintln(c, filter); err != nil {
+			c.Close()
+			return err
+		}
+
+		ack, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil {
+			c.Close()
+			return err
+		}
+		if strings.TrimSpace(ack) != "ACK" {
+			c.Close()
+			return fmt.Errorf("unexpected reaper response %q", ack)
+		}
+
+		conn = c
+		return nil
+	})
+	if err != nil {
+		_ = Purge(context.Background(), pool, resource)
+		return fmt.Errorf("couldn't connect to reaper: %w", err)
+	}
+
+	// The connection is kept open for the lifetime of the process, the
+	// reaper starts removing the session's resources once it's closed.
+	reaperConn = conn
+	return nil
+}
+
+func dockerSocket(pool *dockertest.Pool) string {
+	if endpoint := pool.Client.Endpoint(); strings.HasPrefix(endpoint, "unix://") {
+		return strings.TrimPrefix(endpoint, "unix://")
+	}
+	return "/var/run/docker.sock"
+}
@@ -4,13 +4,12 @@ import (
 	"context"
 	"fmt"
 	dockertestsetup "github.com/kitavrus/dockertestsetup/v7"
-	dockertest "github.com/ory/dockertest/v3"
-	docker "github.com/ory/dockertest/v3/docker"
 	"github.com/redis/go-redis/v9"
 	"strconv"
-	"time"
 )
 
+const DefaultName = "redis"
+
 func newDefaultConfig() dockertestsetup.Config {
 	const (
 		redisPassword = ""
@@ -50,86 +49,56 @@ type ContainerImpl struct {
 }
 
 func (con *ContainerImpl) Up() dockertestsetup.Resource {
+	return con.UpContext(context.Background())
+}
+
+func (con *ContainerImpl) UpContext(ctx context.Context) dockertestsetup.Resource {
 
 	var (
-		db          *redis.Client
 		redisConfig = con.Config.(*RedisConfig)
+		r           = &Resource{}
 	)
-	ctx := context.Background()
-
-	resource, pool, err := con.Config.Connect()
-	if err != nil {
-		return con.resourceWithError(fmt.Errorf("%w", err))
-	}
 
-	err = resource.Expire(con.Config.ResourceExpire())
-	if err != nil {
-		return con.resourceWithError(fmt.Errorf("%w", err))
-	}
+	r.BaseResource = dockertestsetup.StartResource(ctx, con.Config, func(ctx context.Context, base *dockertestsetup.BaseResource) error {
+		addr := base.ConnInfo().DSN
 
-	pool.MaxWait = con.Config.PoolMaxWait()
-	if err = pool.Retry(func() error {
-		db = redis.NewClient(&redis.Options{
-			Addr: fmt.Sprintf("localhost:%s", resource.GetPort(con.Config.ContainerPortId())),
+		r.DB = redis.NewClient(&redis.Options{
+			Addr: addr,
+		})
+		base.AddCleanup(func(context.Context) error {
+			_ = r.DB.Close()
+			return nil
 		})
 
-		return db.Ping(ctx).Err()
-	}); err != nil {
-		con.resourceWithError(fmt.Errorf("could not connect to redis: %s", err))
-	}
-
-	redisConfig.cleanup = func() error {
-		if resource != nil {
-			if err := pool.Purge(resource); err != nil {
-				return fmt.Errorf("Couldn't purge container: %w", err)
-			}
+		if err := dockertestsetup.Retry(ctx, con.Config.PoolMaxWait(), func() error {
+			return r.DB.Ping(ctx).Err()
+		}); err != nil {
+			return dockertestsetup.NewStartupError(con.Name(), dockertestsetup.PhaseReady, dockertestsetup.ErrReadinessTimeout, fmt.Errorf("could not connect to redis: %w", err))
 		}
 
-		return nil
-	}
-
-	return &Resource{
-		Name:     con.Name(),
-		DB:       db,
-		resource: resource,
-		pool:     pool,
-		cleanup:  redisConfig.cleanup,
-		error:    nil,
-		config:   con.Config,
-	}
-}
+		ci := base.ConnInfo()
+		ci.DSN = fmt.Sprintf("redis://%s/%d", addr, redisConfig.RedisDB)
+		base.SetConnInfo(ci)
 
-type Resource struct {
-	Name     string
-	DB       *redis.Client
-	resource *dockertest.Resource
-	pool     *dockertest.Pool
-	cleanup  func() error
-	error    error
-	config   dockertestsetup.Config
-}
+		internal := base.InternalConnInfo()
+		internal.DSN = fmt.Sprintf("redis://%s/%d", internal.DSN, redisConfig.RedisDB)
+		base.SetInternalConnInfo(internal)
 
-func (r *Resource) GetName() string {
-	return r.Name
-}
-
-func (r *Resource) GetError() error {
-	return r.error
-}
+		return nil
+	})
 
-func (r *Resource) Cleanup() error {
-	return r.cleanup()
+	return r
 }
 
-func (r *Resource) Resource() *dockertest.Resource {
-	return r.resource
+type Resource struct {
+	*dockertestsetup.BaseResource
+	DB *redis.Client
 }
 
-func (r *Resource) Pool() *dockertest.Pool {
-	return r.pool
-}
-func (r *Resource) Config() dockertestsetup.Config {
-	return r.config
+// From returns the Redis resource started under DefaultName. Use
+// dockertestsetup.Get for containers started with a custom name.
+func From(dtu *dockertestsetup.DockerTestUpper) (*Resource, error) {
+	return dockertestsetup.Get[*Resource](dtu, DefaultName)
 }
 
 func CfgRedisPassword(p string) dockertestsetup.Options {
@@ -144,139 +113,17 @@ func CfgRedisDb(db uint) dockertestsetup.Options {
 	}
 }
 
-func (con *ContainerImpl) resourceWithError(err error) dockertestsetup.Resource {
-	return &Resource{
-		Name:    con.Name(),
-		cleanup: con.Cleanup,
-		error:   err,
-	}
-}
-
 type RedisConfig struct {
 	dockertestsetup.DockerConfig
 	RedisPassword string
 	RedisDB       uint
-	cleanup       func() error
 }
 
 func (c *RedisConfig) updateDockerConfig() {
-
-	var name = "redis"
-	if len(c.Name()) != 0 {
-		name = c.Name()
-	}
-
-	var repository = "redis"
-	if len(c.Repository()) != 0 {
-		repository = c.Repository()
-	}
-
-	var tag = "3.2"
-	if len(c.Tag()) != 0 {
-		tag = c.Tag()
-	}
-
-	//var redisPassword = ""
-	//if len(c.RedisPassword) != 0 {
-	//	redisPassword = c.RedisPassword
-	//}
-	//
-	//var redisDb = 0
-	//if c.RedisDB != 0 {
-	//	redisDb = c.RedisDB
-	//}
-
-	var hostPort = "6380"
-	if len(c.HostPort()) != 0 {
-		hostPort = c.HostPort()
-	}
-
-	var containerPortId docker.Port = "6379/tcp"
-	if len(c.ContainerPortId()) != 0 {
-		containerPortId = docker.Port(c.ContainerPortId())
-	}
-
-	var env []string
-	if len(c.Env()) != 0 {
-		env = c.Env()
-	}
-
-	var cmd []string
-	if len(c.Cmd()) != 0 {
-		cmd = c.Cmd()
-	}
-
-	var entrypoint []string
-	if len(c.Entrypoint()) != 0 {
-		entrypoint = c.Entrypoint()
-	}
-
-	var workingDir []string
-	if len(c.WorkingDir()) != 0 {
-		workingDir = c.WorkingDir()
-	}
-
-	var autoRemove bool
-	if c.AutoRemove() {
-		autoRemove = c.AutoRemove()
-	}
-
-	var resourceExpire uint
-	if c.ResourceExpire() > 0 {
-		resourceExpire = c.ResourceExpire()
-	} else {
-		resourceExpire = 60
-	}
-
-	var poolMaxWait time.Duration
-	if c.PoolMaxWait() > 0 {
-		poolMaxWait = c.PoolMaxWait()
-	} else {
-		poolMaxWait = 50 * time.Second
-	}
-
-	var restartPolicy docker.RestartPolicy
-	if c.RestartPolicy() != restartPolicy {
-		restartPolicy = c.RestartPolicy()
-	} else {
-		restartPolicy = docker.RestartPolicy{
-			Name: "no",
-		}
-	}
-
-	var portBindings map[docker.Port][]docker.PortBinding
-	if len(c.PortBindings()) != 0 {
-		portBindings = c.PortBindings()
-	} else {
-		portBindings = map[docker.Port][]docker.PortBinding{
-			containerPortId: {{HostPort: hostPort}},
-		}
-	}
-
-	var cleanup func() error
-	if c.cleanup != nil {
-		cleanup = c.cleanup
-	} else {
-		cleanup = func() error { return nil }
-	}
-
-	dockerConfig := dockertestsetup.NewDockerConfig(
-		name,
-		repository,
-		tag,
-		env,
-		cmd,
-		entrypoint,
-		workingDir,
-		autoRemove,
-		resourceExpire,
-		poolMaxWait,
-		restartPolicy,
-		portBindings,
-		cleanup,
-		hostPort,
-		string(containerPortId),
-	)
-
-	c.DockerConfig = dockerConfig
+	dockertestsetup.ApplyDefaults(c.DockerConfig, dockertestsetup.ServiceDefaults{
+		Name:            DefaultName,
+		Repository:      "redis",
+		Tag:             "3.2",
+		ContainerPortId: "6379/tcp",
+	})
 }
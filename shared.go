@@ -0,0 +1,186 @@
+package dockertestsetup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const LabelShared = "dockertestsetup.shared"
+
+// sharedState is kept next to the lock file of a shared container. Container
+// labels can't be changed once the container is created, so the processes
+// using the container are tracked here instead.
+type sharedState struct {
+	ContainerID string `json:"container_id"`
+	Hash        string `json:"hash"`
+	PIDs        []int  `json:"pids"`
+}
+
+func sharedPaths(name string) (lockPath, statePath string, err error) {
+	dir := filepath.Join(os.TempDir(), "dockertestsetup")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, name+".lock"), filepath.Join(dir, name+".json"), nil
+}
+
+func readSharedState(path string) (sharedState, error) {
+	var state sharedState
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(b, &state); err != nil {
+		// other processes may still use the container, so don't guess
+		return sharedState{}, fmt.Errorf("corrupt shared state %s, remove it once no test process uses the container: %w", path, err)
+	}
+
+	alive := state.PIDs[:0]
+	for _, pid := range state.PIDs {
+		if processAlive(pid) {
+			alive = append(alive, pid)
+		}
+	}
+	state.PIDs = alive
+
+	return state, nil
+}
+
+func writeSharedState(path string, state sharedState) error {
+	if len(state.PIDs) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so that a crash can't leave a partial file behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func withSharedLock(ctx context.Context, name string, f func(statePath string) error) error {
+	lockPath, statePath, err := sharedPaths(name)
+	if err != nil {
+		return fmt.Errorf("couldn't prepare lock file: %w", err)
+	}
+
+	unlock, err := lockFile(ctx, lockPath)
+	if err != nil {
+		return fmt.Errorf("couldn't lock %s: %w", lockPath, err)
+	}
+	defer unlock()
+
+	return f(statePath)
+}
+
+func (c *DockerConfigImpl) connectShared(ctx context.Context, pool *dockertest.Pool) (*dockertest.Resource, error) {
+	var resource *dockertest.Resource
+
+	err := withSharedLock(ctx, c.Name(), func(statePath string) error {
+		state, err := readSharedState(statePath)
+		if err != nil {
+			return err
+		}
+
+		hash := ConfigHash(c)
+		existing, ok := c.containerByName(pool)
+
+		switch {
+		case ok && existing.Container.ID == state.ContainerID && state.Hash == hash && isHealthy(existing.Container):
+			resource = existing
+		case ok && existing.Container.ID == state.ContainerID && len(state.PIDs) > 0:
+			return fmt.Errorf("shared container %q is in use by processes %v with a different configuration or is unhealthy", c.Name(), state.PIDs)
+		default:
+			if ok {
+				if existing.Container.ID != state.ContainerID {
+					if err := checkRemovable(c, existing.Container, hash); err != nil {
+						return NewStartupError(c.Name(), PhaseRun, ErrNameInUse, err)
+					}
+				}
+				if err := Purge(ctx, pool, existing); err != nil {
+					return fmt.Errorf("couldn't remove stale container: %w", err)
+				}
+			}
+
+			resource, err = c.create(ctx, pool, map[string]string{
+				LabelConfigHash: hash,
+				LabelShared:     "true",
+			})
+			if err != nil {
+				return err
+			}
+			state = sharedState{ContainerID: resource.Container.ID, Hash: hash}
+		}
+
+		state.PIDs = append(state.PIDs, os.Getpid())
+		return writeSharedState(statePath, state)
+	})
+	if err != nil {
+		var se *StartupError
+		if errors.As(err, &se) {
+			return nil, err
+		}
+		return nil, NewStartupError(c.Name(), PhaseRun, ErrStartFailed, err)
+	}
+
+	return resource, nil
+}
+
+func (c *DockerConfigImpl) releaseShared(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	return withSharedLock(ctx, c.Name(), func(statePath string) error {
+		state, err := readSharedState(statePath)
+		if err != nil {
+			return err
+		}
+
+		if state.ContainerID == resource.Container.ID {
+			pid := os.Getpid()
+			for i, p := range state.PIDs {
+				if p == pid {
+					state.PIDs = append(state.PIDs[:i], state.PIDs[i+1:]...)
+					break
+				}
+			}
+			if len(state.PIDs) > 0 {
+				return writeSharedState(statePath, state)
+			}
+		}
+
+		if err := c.purge(ctx, pool, resource); err != nil {
+			return err
+		}
+		return writeSharedState(statePath, sharedState{})
+	})
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package dockertestsetup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// EnvSkipIfNoDocker overrides WithSkipIfNoDocker when set to a boolean value,
+// e.g. DOCKERTESTSETUP_SKIP_NO_DOCKER=false on CI to require Docker.
+const EnvSkipIfNoDocker = "DOCKERTESTSETUP_SKIP_NO_DOCKER"
+
+// NewT starts the containers like New and registers t.Cleanup to purge all of
+// them once the test and its subtests are finished, even if the test panics.
+// It fails the test with t.Fatalf if any container could not be started.
+func NewT(t testing.TB, conts ...Container) *DockerTestUpper {
+	t.Helper()
+	return NewUpper().StartT(t, conts...)
+}
+
+// StartT is like NewT, but starts the containers on dtu.
+func (dtu *DockerTestUpper) StartT(t testing.TB, conts ...Container) *DockerTestUpper {
+	t.Helper()
+
+	dtu.start(context.Background(), conts)
+	t.Cleanup(func() {
+		if t.Failed() {
+			var logs strings.Builder
+			dtu.dumpLogs(context.Background(), &logs)
+			if logs.Len() != 0 {
+				t.Log("\n" + logs.String())
+			}
+		}
+		if err := dtu.CleanupAll(); err != nil {
+			t.Errorf("couldn't cleanup containers:\n%s", err)
+		}
+	})
+
+	if err := dtu.Err(); err != nil {
+		if dtu.shouldSkip(err) {
+			t.Skipf("skipping, no Docker daemon is reachable:\n%s", err)
+		}
+		t.Fatalf("couldn't start containers:\n%s", err)
+	}
+
+	return dtu
+}
+
+// RunMain is meant to be called from TestMain as os.Exit(RunMain(m, ...)).
+// It starts the containers, runs the tests and purges the containers
+// afterwards. The containers are also purged when TestMain panics or the
+// process receives SIGINT or SIGTERM, in which case the process exits with
+// 128 plus the signal number.
+//
+// A panic inside a test function crashes the test binary from the test's own
+// goroutine and can't be recovered here; ResourceExpire stops the containers
+// in that case, and WithReaper(true) removes them once the process is gone.
+func RunMain(m *testing.M, conts ...Container) int {
+	return NewUpper().RunMain(m, conts...)
+}
+
+// RunMain is like the package level RunMain, but starts the containers on
+// dtu, so that tests can look the resources up from a package variable:
+//
+//	var dtu dockertestsetup.DockerTestUpper
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(dtu.RunMain(m, postgres.New()))
+//	}
+//
+// When dtu skips without Docker, no tests are run and 0 is returned.
+func (dtu *DockerTestUpper) RunMain(m *testing.M, conts ...Container) (code int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			cancel()
+			<-started
+			cleanupAll(dtu)
+			os.Exit(signalExitCode(sig))
+		case <-ctx.Done():
+		}
+	}()
+
+	defer func() {
+		if p := recover(); p != nil {
+			cleanupAll(dtu)
+			panic(p)
+		}
+	}()
+
+	dtu.start(ctx, conts)
+	close(started)
+
+	if err := dtu.Err(); err != nil {
+		if dtu.shouldSkip(err) {
+			cleanupAll(dtu)
+			fmt.Fprintf(os.Stderr, "skipping, no Docker daemon is reachable:\n%s\n", err)
+			return 0
+		}
+		dtu.dumpLogs(ctx, os.Stderr)
+		cleanupAll(dtu)
+		fmt.Fprintf(os.Stderr, "couldn't start containers:\n%s\n", err)
+		return 1
+	}
+
+	code = m.Run()
+	if code != 0 {
+		dtu.dumpLogs(ctx, os.Stderr)
+	}
+	cleanupAll(dtu)
+
+	return code
+}
+
+func (dtu *DockerTestUpper) shouldSkip(err error) bool {
+	if !errors.Is(err, ErrDockerUnavailable) {
+		return false
+	}
+
+	if v, ok := os.LookupEnv(EnvSkipIfNoDocker); ok {
+		if skip, err := strconv.ParseBool(v); err == nil {
+			return skip
+		}
+	}
+	return dtu.skipIfNoDocker
+}
+
+func cleanupAll(dtu *DockerTestUpper) {
+	if err := dtu.CleanupAll(); err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't cleanup containers:\n%s\n", err)
+	}
+}
+
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,125 @@
+package dockertestsetup
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// recordingTB records what StartT does to a test. Fatalf and Skipf end the
+// calling goroutine like the real ones.
+type recordingTB struct {
+	testing.TB
+	cleanups []func()
+	failed   bool
+	skipped  bool
+	errors   []string
+}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Cleanup(f func()) {
+	tb.cleanups = append(tb.cleanups, f)
+}
+
+func (tb *recordingTB) Failed() bool {
+	return tb.failed
+}
+
+func (tb *recordingTB) Log(args ...any) {}
+
+func (tb *recordingTB) Errorf(format string, args ...any) {
+	tb.failed = true
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func (tb *recordingTB) Fatalf(format string, args ...any) {
+	tb.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (tb *recordingTB) Skipf(format string, args ...any) {
+	tb.skipped = true
+	runtime.Goexit()
+}
+
+// run calls f like the testing package calls a test function and runs the
+// registered cleanups afterwards.
+func (tb *recordingTB) run(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+func TestStartT(t *testing.T) {
+	noDocker := WithEndpoint("unix://" + filepath.Join(t.TempDir(), "docker.sock"))
+
+	tests := []struct {
+		name    string
+		opts    []UpperOptions
+		env     string
+		conts   []Container
+		failed  bool
+		skipped bool
+	}{
+		{
+			name:   "invalid containers fail the test",
+			conts:  []Container{newTestContainer("a"), newTestContainer("a")},
+			failed: true,
+		},
+		{
+			name:   "no docker fails the test",
+			opts:   []UpperOptions{noDocker},
+			conts:  []Container{newTestContainer("a")},
+			failed: true,
+		},
+		{
+			name:    "no docker skips the test",
+			opts:    []UpperOptions{noDocker, WithSkipIfNoDocker(true)},
+			conts:   []Container{newTestContainer("a")},
+			skipped: true,
+		},
+		{
+			name:   "environment overrides skip",
+			opts:   []UpperOptions{noDocker, WithSkipIfNoDocker(true)},
+			env:    "false",
+			conts:  []Container{newTestContainer("a")},
+			failed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// an empty value doesn't parse and leaves the decision to the option
+			t.Setenv(EnvSkipIfNoDocker, tt.env)
+
+			tb := &recordingTB{}
+			returned := false
+			tb.run(func() {
+				NewUpper(tt.opts...).StartT(tb, tt.conts...)
+				returned = true
+			})
+
+			if returned {
+				t.Fatal("StartT returned although the containers didn't start")
+			}
+			if len(tb.cleanups) != 1 {
+				t.Fatalf("registered %d cleanups, want 1", len(tb.cleanups))
+			}
+			if tb.failed != tt.failed || tb.skipped != tt.skipped {
+				t.Fatalf("failed = %v, skipped = %v, want %v, %v (errors %q)", tb.failed, tb.skipped, tt.failed, tt.skipped, tb.errors)
+			}
+			if len(tb.errors) > 1 {
+				t.Fatalf("cleanup failed: %q", tb.errors[1:])
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package dockertestsetup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	dockertest "github.com/ory/dockertest/v3"
+	docker "github.com/ory/dockertest/v3/docker"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// WaitStrategy blocks until the container of resource is ready to be used or
+// ctx is done.
+type WaitStrategy interface {
+	WaitUntilReady(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error
+}
+
+// WaitStrategyFunc adapts a function to a WaitStrategy.
+type WaitStrategyFunc func(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error
+
+func (f WaitStrategyFunc) WaitUntilReady(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	return f(ctx, pool, resource)
+}
+
+const waitPollInterval = 200 * time.Millisecond
+
+// WaitUntilReady runs the wait strategy of c, if any, until it succeeds or
+// ctx is done. StartResource limits ctx to PoolMaxWait.
+func WaitUntilReady(ctx context.Context, c Config, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	ws := c.WaitStrategy()
+	if ws == nil {
+		return nil
+	}
+
+	if err := ws.WaitUntilReady(ctx, pool, resource); err != nil {
+		return NewStartupError(c.Name(), PhaseReady, ErrReadinessTimeout, err)
+	}
+	return nil
+}
+
+// poll calls check until it succeeds or ctx is done.
+func poll(ctx context.Context, check func() error) error {
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+
+		if sleepErr := sleepContext(ctx, waitPollInterval); sleepErr != nil {
+			return fmt.Errorf("%w: %v", sleepErr, err)
+		}
+	}
+}
+
+// ForListeningPort waits until the port is listening inside the container
+// and the host port mapped to it accepts TCP connections. docker-proxy and
+// Docker Desktop accept connections on the host port before the service
+// listens, so the host side alone doesn't prove anything. The inside check
+// reads /proc/net/tcp with sh and grep and is skipped for images without
+// them.
+func ForListeningPort(port string) WaitStrategy {
+	return WaitStrategyFunc(func(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+		number, err := strconv.Atoi(docker.Port(port).Port())
+		inside := err == nil && docker.Port(port).Proto() == "tcp"
+
+		return poll(ctx, func() error {
+			if inside {
+				listening, err := listeningInside(ctx, pool, resource, number)
+				if err != nil {
+					return err
+				}
+				switch listening {
+				case portNotListening:
+					return fmt.Errorf("port %s isn't listening inside the container", port)
+				case portCheckUnsupported:
+					inside = false
+				}
+			}
+
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", resource.GetHostPort(port))
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		})
+	})
+}
+
+type portState int
+
+const (
+	portListening portState = iota
+	portNotListening
+	portCheckUnsupported
+)
+
+// listeningInside looks for a socket in state LISTEN (0A) on port in the
+// tables of the container's network namespace.
+func listeningInside(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource, port int) (portState, error) {
+	script := fmt.Sprintf("cat /proc/net/tcp /proc/net/tcp6 2>/dev/null | grep -Eqi ':%04X [0-9a-f]+:[0-9a-f]+ 0A '", port)
+
+	_, _, code, err := Exec(ctx, pool, resource, []string{"sh", "-c", script})
+	switch {
+	case err != nil:
+		if ctx.Err() != nil {
+			return portNotListening, err
+		}
+		// e.g. no sh in a distroless image
+		return portCheckUnsupported, nil
+	case code == 0:
+		return portListening, nil
+	case code == 1:
+		return portNotListening, nil
+	default:
+		// 126 and 127: sh, cat or grep missing
+		return portCheckUnsupported, nil
+	}
+}
+
+type HTTPStrategy struct {
+	port   string
+	path   string
+	status int
+	body   *regexp.Regexp
+	err    error
+}
+
+// ForHTTP waits until a GET request to path on the host port mapped to port
+// returns 200 OK, or the status set with WithStatus.
+func ForHTTP(port, path string) *HTTPStrategy {
+	return &HTTPStrategy{
+		port:   port,
+		path:   path,
+		status: http.StatusOK,
+	}
+}
+
+func (s *HTTPStrategy) WithStatus(status int) *HTTPStrategy {
+	s.status = status
+	return s
+}
+
+// WithBody additionally requires the response body to match pattern. An
+// invalid pattern is reported by Err and fails the container before it's
+// created.
+func (s *HTTPStrategy) WithBody(pattern string) *HTTPStrategy {
+	s.body, s.err = regexp.Compile(pattern)
+	return s
+}
+
+func (s *HTTPStrategy) Err() error {
+	return s.err
+}
+
+func (s *HTTPStrategy) WaitUntilReady(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	url := fmt.Sprintf("http://%s%s", resource.GetHostPort(s.port), s.path)
+
+	return poll(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != s.status {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+
+		if s.body != nil {
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			if !s.body.Match(b) {
+				return fmt.Errorf("body doesn't match %q", s.body)
+			}
+		}
+
+		return nil
+	})
+}
+
+type LogStrategy struct {
+	pattern    *regexp.Regexp
+	occurrence int
+	err        error
+}
+
+// ForLog waits until the container output matches pattern. An invalid
+// pattern is reported by Err and fails the container before it's created.
+func ForLog(pattern string) *LogStrategy {
+	re, err := regexp.Compile(pattern)
+	return &LogStrategy{
+		pattern:    re,
+		occurrence: 1,
+		err:        err,
+	}
+}
+
+func (s *LogStrategy) Err() error {
+	return s.err
+}
+
+// WithOccurrence requires pattern to match n times, e.g. Postgres logs that
+// it's ready twice, once before and once after running its init scripts.
+func (s *LogStrategy) WithOccurrence(n int) *LogStrategy {
+	s.occurrence = n
+	return s
+}
+
+func (s *LogStrategy) WaitUntilReady(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	return poll(ctx, func() error {
+		var buf bytes.Buffer
+		if err := pool.Client.Logs(docker.LogsOptions{
+			Context:      ctx,
+			Container:    resource.Container.ID,
+			OutputStream: &buf,
+			ErrorStream:  &buf,
+			Stdout:       true,
+			Stderr:       true,
+		}); err != nil {
+			return err
+		}
+
+		if n := len(s.pattern.FindAllIndex(buf.Bytes(), -1)); n < s.occurrence {
+			return fmt.Errorf("log matched %q %d out of %d times", s.pattern, n, s.occurrence)
+		}
+		return nil
+	})
+}
+
+type ExecStrategy struct {
+	cmd      []string
+	exitCode int
+}
+
+// ForExec waits until cmd run inside the container exits with 0, or the
+// code set with WithExitCode.
+func ForExec(cmd ...string) *ExecStrategy {
+	return &ExecStrategy{cmd: cmd}
+}
+
+func (s *ExecStrategy) WithExitCode(code int) *ExecStrategy {
+	s.exitCode = code
+	return s
+}
+
+func (s *ExecStrategy) WaitUntilReady(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	return poll(ctx, func() error {
+		_, _, code, err := Exec(ctx, pool, resource, s.cmd)
+		if err != nil {
+			return err
+		}
+		if code != s.exitCode {
+			return fmt.Errorf("%v exited with %d", s.cmd, code)
+		}
+		return nil
+	})
+}
+
+// ForHealthcheck waits until Docker reports the container as healthy, which
+// requires the image to define a HEALTHCHECK.
+func ForHealthcheck() WaitStrategy {
+	return WaitStrategyFunc(func(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+		return poll(ctx, func() error {
+			c, err := pool.Client.InspectContainerWithContext(resource.Container.ID, ctx)
+			if err != nil {
+				return err
+			}
+			if c.State.Health.Status != "healthy" {
+				return fmt.Errorf("container is %q", c.State.Health.Status)
+			}
+			return nil
+		})
+	})
+}
+
+type strategyGroup struct {
+	strategies []WaitStrategy
+	wait       WaitStrategyFunc
+}
+
+func (g *strategyGroup) WaitUntilReady(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+	return g.wait(ctx, pool, resource)
+}
+
+func (g *strategyGroup) Err() error {
+	var errs []error
+	for _, s := range g.strategies {
+		if err := strategyErr(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// strategyErr returns the configuration error of ws, for strategies that
+// report one through an Err method.
+func strategyErr(ws WaitStrategy) error {
+	if v, ok := ws.(interface{ Err() error }); ok {
+		return v.Err()
+	}
+	return nil
+}
+
+// ForAll waits for every strategy in turn.
+func ForAll(strategies ...WaitStrategy) WaitStrategy {
+	return &strategyGroup{
+		strategies: strategies,
+		wait: func(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+			for _, s := range strategies {
+				if err := s.WaitUntilReady(ctx, pool, resource); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// ForAny runs the strategies concurrently and returns as soon as one of them
+// succeeds.
+func ForAny(strategies ...WaitStrategy) WaitStrategy {
+	return &strategyGroup{
+		strategies: strategies,
+		wait: func(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) error {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			errs := make(chan error, len(strategies))
+			for _, s := range strategies {
+				go func(s WaitStrategy) {
+					errs <- s.WaitUntilReady(ctx, pool, resource)
+				}(s)
+			}
+
+			var all []error
+			for range strategies {
+				err := <-errs
+				if err == nil {
+					return nil
+				}
+				all = append(all, err)
+			}
+			return errors.Join(all...)
+		},
+	}
+}